@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// The archive cache keeps every plugin archive krew installs under
+// <archiveCacheDir>/sha256/<sum>, keyed by the checksum in the manifest.
+// Installs of default-index plugins go through it, so an archive is
+// downloaded once per volume and reinstalls work without network access.
+
+var (
+	archiveFetchMu sync.Mutex
+	archiveFetches = make(map[string]*sync.Mutex)
+)
+
+func archiveCacheEnabled() bool {
+	return os.Getenv("ARCHIVE_CACHE") != "false"
+}
+
+func cachedArchivePath(sum string) string {
+	return filepath.Join(archiveCacheDir(), "sha256", sum)
+}
+
+// lockArchive serializes fetches of one archive.
+func lockArchive(sum string) func() {
+	archiveFetchMu.Lock()
+	mu, ok := archiveFetches[sum]
+	if !ok {
+		mu = &sync.Mutex{}
+		archiveFetches[sum] = mu
+	}
+	archiveFetchMu.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
+
+// fetchArchive returns the cached archive of platform p, downloading it
+// from the platform URI first if needed. A download whose sha256 does not
+// match the manifest is discarded with a checksumError.
+func fetchArchive(ctx context.Context, p *pluginPlatform, emit lineFunc) (string, error) {
+	sum := p.SHA256
+	if !sha256Pattern.MatchString(sum) {
+		return "", fmt.Errorf("manifest has an invalid sha256 %q", sum)
+	}
+	path := cachedArchivePath(sum)
+	unlock := lockArchive(sum)
+	defer unlock()
+	if _, err := os.Stat(path); err == nil {
+		emit(streamSystem, "using cached archive "+sum)
+		return path, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	// Another mirror, e.g. a replica on its own volume, comes first.
+	if mirror := os.Getenv("ARCHIVE_MIRROR_URL"); mirror != "" {
+		url := strings.TrimSuffix(mirror, "/") + "/sha256/" + sum
+		err := downloadArchive(ctx, url, sum, path, emit)
+		if err == nil || ctx.Err() != nil {
+			return path, err
+		}
+		emit(streamSystem, fmt.Sprintf("mirror: %v", err))
+	}
+	if err := downloadArchive(ctx, p.URI, sum, path, emit); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// downloadArchive fetches url to path if its sha256 is sum.
+func downloadArchive(ctx context.Context, url, sum, path string, emit lineFunc) error {
+	emit(streamSystem, "downloading "+url)
+	ctx, cancel := context.WithTimeout(ctx, operationTimeout("install"))
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("download %s: HTTP %d", url, resp.StatusCode)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("download %s: %w", url, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != sum {
+		return &checksumError{Archive: filepath.Base(url), Got: got, Want: sum}
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// cacheablePlatform returns the host platform of entry if its archive may
+// go into the cache. The mirror serves the cache without a session, so only
+// default-index plugins qualify, and only as the backend's own checkout of
+// the default index has them: workspace users can edit theirs.
+func cacheablePlatform(entry *indexPlugin) (*pluginPlatform, error) {
+	if entry.Index != defaultIndex {
+		return nil, fmt.Errorf("only plugins of the %s index are cached", defaultIndex)
+	}
+	p := hostPlatform(entry.Manifest.Spec)
+	if p == nil {
+		return nil, fmt.Errorf("plugin %s has no platform for %s/%s", entry.Manifest.Metadata.Name, runtime.GOOS, runtime.GOARCH)
+	}
+	shared, found, err := catalogFor(sharedWorkspace()).get(entry.Manifest.Metadata.Name)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if sp := hostPlatform(shared.Manifest.Spec); sp != nil && sp.URI == p.URI && strings.EqualFold(sp.SHA256, p.SHA256) {
+			return sp, nil
+		}
+	}
+	return nil, fmt.Errorf("plugin %s %s differs from the backend's %s index", entry.Manifest.Metadata.Name, entry.Manifest.Spec.Version, defaultIndex)
+}
+
+// installPlugin installs name in ws. Default-index plugins are installed
+// from the archive cache; anything else, or a failing cache, falls back to
+// a plain `krew install`.
+func installPlugin(ctx context.Context, ws *workspace, name string, emit lineFunc) error {
+	entry, found, err := catalogFor(ws).get(name)
+	if err != nil || !found || entry.Index != defaultIndex || !archiveCacheEnabled() {
+		return runKrewStream(ctx, ws, emit, "install", name)
+	}
+	p, err := cacheablePlatform(entry)
+	if err != nil {
+		emit(streamSystem, fmt.Sprintf("archive cache: %v; installing without it", err))
+		return runKrewStream(ctx, ws, emit, "install", name)
+	}
+	archive, err := fetchArchive(ctx, p, emit)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		emit(streamSystem, fmt.Sprintf("archive cache: %v; installing without it", err))
+		return runKrewStream(ctx, ws, emit, "install", name)
+	}
+	return installArchive(ctx, ws, name, entry.Index, entry.File, archive, emit)
+}
+
+// installArchive installs the plugin name from a manifest and archive on
+// disk. If the manifest comes from index, the receipt says so afterwards.
+func installArchive(ctx context.Context, ws *workspace, name, index, manifest, archive string, emit lineFunc) error {
+	if err := runKrewStream(ctx, ws, emit, "install", "--manifest="+manifest, "--archive="+archive); err != nil {
+		return err
+	}
+	if index == "" {
+		return nil
+	}
+	release, err := krewQueueFor(ws.KrewRoot).acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return restoreReceiptSource(ws, name, index)
+}
+
+// restoreReceiptSource sets the index in the receipt of name. Krew records
+// installs from a manifest file as coming from the "detached" index, which
+// would make the plugin unknown to its index: not shown as installed, not
+// upgradable, and not found by uninstall or upgrade.
+func restoreReceiptSource(ws *workspace, name, index string) error {
+	path := filepath.Join(ws.KrewRoot, "receipts", baseName(name)+".yaml")
+	data, err := ws.readFile(path)
+	if err != nil {
+		return fmt.Errorf("fix receipt of %s: %w", name, err)
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil || len(doc.Content) == 0 {
+		return fmt.Errorf("fix receipt of %s: cannot parse %s", name, path)
+	}
+	n := doc.Content[0]
+	for _, key := range []string{"status", "source"} {
+		next := yamlPath(n, key)
+		if next == nil {
+			next = &yaml.Node{Kind: yaml.MappingNode}
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, next)
+		}
+		n = next
+	}
+	setYAMLField(n, "name", index)
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return err
+	}
+	return ws.writeFile(path, out.Bytes(), 0644)
+}
+
+// cacheEntry is one archive in the cache.
+type cacheEntry struct {
+	SHA256   string    `json:"sha256"`
+	Size     int64     `json:"size"`
+	CachedAt time.Time `json:"cachedAt"`
+}
+
+// listArchiveCache returns the cached archives, newest first.
+func listArchiveCache() ([]cacheEntry, int64, error) {
+	files, err := os.ReadDir(filepath.Join(archiveCacheDir(), "sha256"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, err
+	}
+	entries := []cacheEntry{}
+	var total int64
+	for _, f := range files {
+		if !sha256Pattern.MatchString(f.Name()) {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, cacheEntry{SHA256: f.Name(), Size: info.Size(), CachedAt: info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].CachedAt.After(entries[j].CachedAt) })
+	return entries, total, nil
+}
+
+// serveMirror serves a cached archive by sha256. The cache only holds
+// archives of the public default index (see cacheablePlatform), so no
+// session is needed. Other replicas point ARCHIVE_MIRROR_URL here.
+func serveMirror(c *gin.Context) {
+	sum := c.Param("sha256")
+	if !sha256Pattern.MatchString(sum) {
+		c.JSON(404, gin.H{"error": "archive not found"})
+		return
+	}
+	path := cachedArchivePath(sum)
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(404, gin.H{"error": "archive not found"})
+		return
+	}
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.FileAttachment(path, sum)
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Hashes of the files krew unpacked are recorded right after an install or
+// upgrade, outside the workspace so its user cannot rewrite them, and the
+// audit compares the store against them later.
+
+// pluginRecord is what was installed for one plugin.
+type pluginRecord struct {
+	Name       string            `json:"name"`
+	Version    string            `json:"version"`
+	SHA256     string            `json:"sha256"` // archive checksum from the receipt
+	RecordedAt time.Time         `json:"recordedAt"`
+	Files      map[string]string `json:"files"` // path in the store -> sha256
+}
+
+// pluginAudit is the audit result of one installed plugin.
+type pluginAudit struct {
+	Name           string   `json:"name"`
+	Version        string   `json:"version"`
+	Status         string   `json:"status"` // "ok", "modified" or "unrecorded"
+	Modified       []string `json:"modified,omitempty"`
+	Missing        []string `json:"missing,omitempty"`
+	Unexpected     []string `json:"unexpected,omitempty"`
+	ReceiptSHA256  string   `json:"receiptSha256,omitempty"`
+	ManifestSHA256 string   `json:"manifestSha256,omitempty"`
+	Issues         []string `json:"issues,omitempty"`
+}
+
+// shimAudit is a suspicious entry of $KREW_ROOT/bin.
+type shimAudit struct {
+	Name   string `json:"name"`
+	Target string `json:"target,omitempty"`
+	Issue  string `json:"issue"`
+}
+
+type auditReport struct {
+	KrewRoot  string        `json:"krewRoot"`
+	CheckedAt time.Time     `json:"checkedAt"`
+	OK        bool          `json:"ok"`
+	Plugins   []pluginAudit `json:"plugins"`
+	Shims     []shimAudit   `json:"shims"`
+}
+
+// auditDir keeps the records of ws, readable by the backend user only.
+func auditDir(ws *workspace) string {
+	key := "shared"
+	if ws.UserID != "" {
+		key = workspaceKey(ws.UserID)
+	}
+	return filepath.Join(workspacesDir(), ".audit", key)
+}
+
+// hashTimeout bounds one pass over the stores of a krew root. The store
+// belongs to the workspace user, who could fill it with endless files.
+const hashTimeout = 5 * time.Minute
+
+// hashStore returns the sha256 of every file in dir, by relative path.
+// Symlinks are recorded by their target; devices, pipes and sockets are
+// skipped, as krew never unpacks them.
+func hashStore(ctx context.Context, dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		switch {
+		case info.IsDir():
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			files[rel] = "symlink:" + target
+		case info.Mode().IsRegular():
+			sum, err := hashRegularFile(ctx, path)
+			if err != nil {
+				return err
+			}
+			files[rel] = sum
+		}
+		return nil
+	})
+	return files, err
+}
+
+// hashRegularFile is fileSHA256 for files of the workspace user: it does not
+// block on a file swapped for a pipe, and gives up when ctx is done.
+func hashRegularFile(ctx context.Context, path string) (string, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", path)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, ctxReader{ctx, f}); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ctxReader fails reads once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// receiptSHA256 is the archive checksum krew recorded for the host
+// platform in the receipt of name.
+func receiptSHA256(ws *workspace, name string) string {
+	data, err := os.ReadFile(filepath.Join(ws.KrewRoot, "receipts", baseName(name)+".yaml"))
+	if err != nil {
+		return ""
+	}
+	var r pluginReceipt
+	if yaml.Unmarshal(data, &r) != nil {
+		return ""
+	}
+	if p := hostPlatform(r.Spec); p != nil {
+		return strings.ToLower(p.SHA256)
+	}
+	return ""
+}
+
+func readRecord(ws *workspace, name string) (*pluginRecord, error) {
+	data, err := os.ReadFile(filepath.Join(auditDir(ws), baseName(name)+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var rec pluginRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	return &rec, nil
+}
+
+// recordPluginHashes records the store of every installed plugin whose
+// version has no record yet, and drops records of removed plugins. With
+// force, existing records are replaced too. A plugin that cannot be recorded
+// does not keep the others from being recorded; all failures are returned.
+func recordPluginHashes(ws *workspace, force bool) error {
+	installed, err := installedPlugins(ws)
+	if err != nil {
+		return err
+	}
+	dir := auditDir(ws)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), hashTimeout)
+	defer cancel()
+	var errs []string
+	for _, p := range installed {
+		if rec, err := readRecord(ws, p.Name); err == nil && rec.Version == p.Version && !force {
+			continue
+		}
+		if err := recordPlugin(ctx, ws, dir, p); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", p.Name, err))
+		}
+	}
+	records, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	for _, f := range records {
+		name := strings.TrimSuffix(filepath.Base(f), ".json")
+		found := false
+		for _, p := range installed {
+			if baseName(p.Name) == name {
+				found = true
+			}
+		}
+		if !found {
+			os.Remove(f)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("record plugin hashes: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// recordPlugin hashes the store of the installed plugin p into dir.
+func recordPlugin(ctx context.Context, ws *workspace, dir string, p *installedPlugin) error {
+	store, err := pluginStoreDir(ws, p.Name, p.Version)
+	if err != nil {
+		return err
+	}
+	files, err := hashStore(ctx, store)
+	if err != nil {
+		return fmt.Errorf("hash: %w", err)
+	}
+	rec := pluginRecord{
+		Name: p.Name, Version: p.Version, SHA256: receiptSHA256(ws, p.Name),
+		RecordedAt: time.Now(), Files: files,
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, baseName(p.Name)+".json"), data, 0600)
+}
+
+// auditPlugins rehashes the store of ws and checks receipts and PATH shims.
+func auditPlugins(ws *workspace) (*auditReport, error) {
+	installed, err := installedPlugins(ws)
+	if err != nil {
+		return nil, err
+	}
+	report := &auditReport{KrewRoot: ws.KrewRoot, CheckedAt: time.Now(), OK: true, Plugins: []pluginAudit{}, Shims: []shimAudit{}}
+	cat := catalogFor(ws)
+	ctx, cancel := context.WithTimeout(context.Background(), hashTimeout)
+	defer cancel()
+	for _, p := range sortedInstalled(installed) {
+		a := pluginAudit{Name: p.Name, Version: p.Version, Status: "ok", ReceiptSHA256: receiptSHA256(ws, p.Name)}
+		if blocked, reason := activePluginPolicy.blocked(p.Name, p.Version); blocked {
+			a.Issues = append(a.Issues, "installed version is blocked by policy: "+reason)
+		}
+
+		if entry, ok, err := cat.get(p.Name); err == nil && ok && entry.Manifest.Spec.Version == p.Version {
+			if mp := hostPlatform(entry.Manifest.Spec); mp != nil {
+				a.ManifestSHA256 = strings.ToLower(mp.SHA256)
+				if a.ReceiptSHA256 != "" && a.ReceiptSHA256 != a.ManifestSHA256 {
+					a.Issues = append(a.Issues, "receipt checksum differs from the index manifest of the same version")
+				}
+			}
+		}
+
+		rec, err := readRecord(ws, p.Name)
+		if err != nil || rec.Version != p.Version {
+			a.Status = "unrecorded"
+			a.Issues = append(a.Issues, "no hashes recorded for this version")
+			report.OK = false
+			report.Plugins = append(report.Plugins, a)
+			continue
+		}
+		if rec.SHA256 != "" && a.ReceiptSHA256 != rec.SHA256 {
+			a.Issues = append(a.Issues, "receipt checksum changed since install")
+		}
+		var files map[string]string
+		store, err := pluginStoreDir(ws, p.Name, p.Version)
+		if err == nil {
+			files, err = hashStore(ctx, store)
+		}
+		switch {
+		case err == nil || os.IsNotExist(err):
+		case ctx.Err() != nil:
+			return nil, fmt.Errorf("hash %s: %w", p.Name, err)
+		default:
+			// The receipt or the store was tampered with; report it
+			// rather than fail the audit of the other plugins.
+			a.Issues = append(a.Issues, err.Error())
+			a.Status = "modified"
+		}
+		for path, sum := range rec.Files {
+			got, ok := files[path]
+			switch {
+			case !ok:
+				a.Missing = append(a.Missing, path)
+			case got != sum:
+				a.Modified = append(a.Modified, path)
+			}
+		}
+		for path := range files {
+			if _, ok := rec.Files[path]; !ok {
+				a.Unexpected = append(a.Unexpected, path)
+			}
+		}
+		sort.Strings(a.Missing)
+		sort.Strings(a.Modified)
+		sort.Strings(a.Unexpected)
+		if len(a.Missing)+len(a.Modified)+len(a.Unexpected) > 0 {
+			a.Status = "modified"
+		}
+		if a.Status != "ok" || len(a.Issues) > 0 {
+			report.OK = false
+		}
+		report.Plugins = append(report.Plugins, a)
+	}
+
+	report.Shims = auditShims(ws, installed)
+	if len(report.Shims) > 0 {
+		report.OK = false
+	}
+	return report, nil
+}
+
+// auditShims flags entries of $KREW_ROOT/bin that do not link into the
+// store or belong to no installed plugin.
+func auditShims(ws *workspace, installed map[string]*installedPlugin) []shimAudit {
+	shims := []shimAudit{}
+	binDir := filepath.Join(ws.KrewRoot, "bin")
+	entries, err := os.ReadDir(binDir)
+	if err != nil {
+		return shims
+	}
+	known := make(map[string]bool)
+	for _, p := range installed {
+		known[pluginBinName(baseName(p.Name))] = true
+	}
+	store, _ := filepath.EvalSymlinks(filepath.Join(ws.KrewRoot, "store"))
+	for _, e := range entries {
+		path := filepath.Join(binDir, e.Name())
+		fi, err := os.Lstat(path)
+		if err != nil {
+			continue
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			shims = append(shims, shimAudit{Name: e.Name(), Issue: "not a symlink into the store"})
+			continue
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			link, _ := os.Readlink(path)
+			shims = append(shims, shimAudit{Name: e.Name(), Target: link, Issue: "dangling symlink"})
+			continue
+		}
+		if store == "" || !strings.HasPrefix(target, store+string(filepath.Separator)) {
+			shims = append(shims, shimAudit{Name: e.Name(), Target: target, Issue: "points outside the store"})
+			continue
+		}
+		if !known[e.Name()] {
+			shims = append(shims, shimAudit{Name: e.Name(), Target: target, Issue: "no installed plugin owns this shim"})
+		}
+	}
+	return shims
+}
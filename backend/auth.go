@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rancherUser is the identity behind a validated Rancher session token.
+type rancherUser struct {
+	ID           string   `json:"id"`
+	Username     string   `json:"username"`
+	Name         string   `json:"name"`
+	PrincipalIDs []string `json:"principalIds"`
+
+	// Filled in by resolveUserRoles for the RBAC policy.
+	Groups      []string `json:"groups,omitempty"`
+	GlobalRoles []string `json:"globalRoles,omitempty"`
+}
+
+const (
+	sessionCacheTTL = time.Minute
+	wsTicketTTL     = 30 * time.Second
+
+	// wsShellProtocol is the subprotocol the server selects on /api/ws/shell.
+	// Browsers cannot set headers on WebSocket requests, so the client sends
+	// the token as a second subprotocol next to this one.
+	wsShellProtocol = "krew.shell"
+	// wsTokenProtocolPrefix follows the Kubernetes convention for passing a
+	// bearer token through Sec-WebSocket-Protocol (base64url, no padding).
+	wsTokenProtocolPrefix = "base64url.bearer.authorization.k8s.io."
+)
+
+type cachedSession struct {
+	user    *rancherUser
+	expires time.Time
+}
+
+type wsTicket struct {
+	user    *rancherUser
+	token   string
+	expires time.Time
+}
+
+var (
+	sessionMu    sync.Mutex
+	sessionCache = make(map[string]cachedSession)
+
+	ticketMu  sync.Mutex
+	wsTickets = make(map[string]wsTicket)
+)
+
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// validateRancherToken resolves token to a Rancher user. Unlike
+// rancherRequestWithToken it never falls back to RANCHER_TOKEN: an empty
+// token is always rejected.
+func validateRancherToken(token string) (*rancherUser, error) {
+	if token == "" {
+		return nil, fmt.Errorf("no Rancher session")
+	}
+	key := tokenKey(token)
+
+	sessionMu.Lock()
+	if s, ok := sessionCache[key]; ok && time.Now().Before(s.expires) {
+		sessionMu.Unlock()
+		return s.user, nil
+	}
+	sessionMu.Unlock()
+
+	body, err := rancherRequestWithToken("GET", "/v3/users?me=true", token)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Data []rancherUser `json:"data"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("parse user response: %w", err)
+	}
+	if len(result.Data) == 0 || result.Data[0].ID == "" {
+		return nil, fmt.Errorf("rancher session has no user")
+	}
+	user := &result.Data[0]
+	resolveUserRoles(user, token)
+
+	sessionMu.Lock()
+	now := time.Now()
+	for k, s := range sessionCache {
+		if now.After(s.expires) {
+			delete(sessionCache, k)
+		}
+	}
+	sessionCache[key] = cachedSession{user: user, expires: now.Add(sessionCacheTTL)}
+	sessionMu.Unlock()
+	return user, nil
+}
+
+// issueWSTicket returns a random single-use ticket that stands in for token
+// on the next WebSocket upgrade.
+func issueWSTicket(user *rancherUser, token string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(b)
+
+	ticketMu.Lock()
+	defer ticketMu.Unlock()
+	now := time.Now()
+	for k, t := range wsTickets {
+		if now.After(t.expires) {
+			delete(wsTickets, k)
+		}
+	}
+	wsTickets[ticket] = wsTicket{user: user, token: token, expires: now.Add(wsTicketTTL)}
+	return ticket, nil
+}
+
+func redeemWSTicket(ticket string) (*rancherUser, string, bool) {
+	ticketMu.Lock()
+	defer ticketMu.Unlock()
+	t, ok := wsTickets[ticket]
+	if !ok {
+		return nil, "", false
+	}
+	delete(wsTickets, ticket)
+	if time.Now().After(t.expires) {
+		return nil, "", false
+	}
+	return t.user, t.token, true
+}
+
+// tokenFromRequest returns the Rancher token sent by the UI, as a bearer
+// Authorization header or X-Rancher-Token.
+func tokenFromRequest(c *gin.Context) string {
+	if h := c.GetHeader("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer ")
+	}
+	return c.GetHeader("X-Rancher-Token")
+}
+
+// wsTokenFromProtocols extracts a bearer token sent as a WebSocket
+// subprotocol.
+func wsTokenFromProtocols(r *http.Request) string {
+	for _, h := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, p := range strings.Split(h, ",") {
+			p = strings.TrimSpace(p)
+			if !strings.HasPrefix(p, wsTokenProtocolPrefix) {
+				continue
+			}
+			raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(p, wsTokenProtocolPrefix))
+			if err == nil {
+				return string(raw)
+			}
+		}
+	}
+	return ""
+}
+
+// authenticateWS resolves the Rancher user for a WebSocket upgrade from, in
+// order: a ticket query parameter, a token subprotocol, or the R_SESS cookie.
+// The returned token is the Rancher token backing the session.
+func authenticateWS(c *gin.Context) (*rancherUser, string, error) {
+	if ticket := c.Query("ticket"); ticket != "" {
+		user, token, ok := redeemWSTicket(ticket)
+		if !ok {
+			return nil, "", fmt.Errorf("invalid or expired ticket")
+		}
+		return user, token, nil
+	}
+	token := wsTokenFromProtocols(c.Request)
+	if token == "" {
+		if cookie, err := c.Cookie("R_SESS"); err == nil {
+			token = cookie
+		}
+	}
+	user, err := validateRancherToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+	return user, token, nil
+}
+
+// checkWSOrigin rejects cross-site WebSocket upgrades. Requests without an
+// Origin header come from non-browser clients and still need a session.
+// The handshake authenticates with the session cookie, so "*" is never
+// enough: the origin must be the backend's own host or listed by name.
+func checkWSOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return activeCORSPolicy.originListed(origin)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultIndex is the name krew gives the index it is installed with.
+// Plugins from it are addressed without an index prefix.
+const defaultIndex = "default"
+
+// pluginManifest is a krew plugin manifest as found in
+// $KREW_ROOT/index/<index>/plugins/<name>.yaml.
+type pluginManifest struct {
+	APIVersion string `yaml:"apiVersion" json:"apiVersion"`
+	Kind       string `yaml:"kind" json:"kind"`
+	Metadata   struct {
+		Name              string `yaml:"name" json:"name"`
+		CreationTimestamp string `yaml:"creationTimestamp" json:"creationTimestamp,omitempty"`
+	} `yaml:"metadata" json:"metadata"`
+	Spec pluginSpec `yaml:"spec" json:"spec"`
+}
+
+type pluginSpec struct {
+	Version          string           `yaml:"version" json:"version"`
+	Homepage         string           `yaml:"homepage" json:"homepage,omitempty"`
+	ShortDescription string           `yaml:"shortDescription" json:"shortDescription"`
+	Description      string           `yaml:"description" json:"description,omitempty"`
+	Caveats          string           `yaml:"caveats" json:"caveats,omitempty"`
+	Platforms        []pluginPlatform `yaml:"platforms" json:"platforms"`
+}
+
+type pluginPlatform struct {
+	Selector *labelSelector `yaml:"selector" json:"selector,omitempty"`
+	URI      string         `yaml:"uri" json:"uri"`
+	SHA256   string         `yaml:"sha256" json:"sha256"`
+	Bin      string         `yaml:"bin" json:"bin"`
+	Files    []struct {
+		From string `yaml:"from" json:"from"`
+		To   string `yaml:"to" json:"to"`
+	} `yaml:"files" json:"files,omitempty"`
+}
+
+type labelSelector struct {
+	MatchLabels      map[string]string     `yaml:"matchLabels" json:"matchLabels,omitempty"`
+	MatchExpressions []selectorRequirement `yaml:"matchExpressions" json:"matchExpressions,omitempty"`
+}
+
+type selectorRequirement struct {
+	Key      string   `yaml:"key" json:"key"`
+	Operator string   `yaml:"operator" json:"operator"`
+	Values   []string `yaml:"values" json:"values,omitempty"`
+}
+
+// indexPlugin is one manifest from one krew index.
+type indexPlugin struct {
+	Index    string
+	File     string // manifest path
+	Manifest pluginManifest
+}
+
+// qualifiedName is the name krew commands accept for the plugin:
+// "name" for the default index, "index/name" otherwise.
+func (p *indexPlugin) qualifiedName() string {
+	if p.Index == defaultIndex {
+		return p.Manifest.Metadata.Name
+	}
+	return p.Index + "/" + p.Manifest.Metadata.Name
+}
+
+func (p *indexPlugin) plugin() Plugin {
+	spec := p.Manifest.Spec
+	blocked, reason := activePluginPolicy.blocked(p.qualifiedName(), spec.Version)
+	return Plugin{
+		Name:             p.qualifiedName(),
+		Description:      spec.ShortDescription,
+		Index:            p.Index,
+		AvailableVersion: spec.Version,
+		Homepage:         spec.Homepage,
+		LongDescription:  strings.TrimSpace(spec.Description),
+		Caveats:          strings.TrimSpace(spec.Caveats),
+		Platforms:        spec.Platforms,
+		Blocked:          blocked,
+		BlockedReason:    reason,
+		Categories:       categorize(p.qualifiedName(), spec),
+	}
+}
+
+// pluginCatalog is the in-memory view of the krew indexes under one
+// KREW_ROOT. It is reloaded when the index directories change, e.g. after
+// `krew update`.
+type pluginCatalog struct {
+	mu        sync.Mutex
+	root      string
+	signature string
+	loadedAt  time.Time
+	plugins   []*indexPlugin
+	byName    map[string]*indexPlugin
+}
+
+var (
+	catalogsMu sync.Mutex
+	catalogs   = make(map[string]*pluginCatalog)
+)
+
+// catalogFor returns the catalog of the krew root of ws.
+func catalogFor(ws *workspace) *pluginCatalog {
+	catalogsMu.Lock()
+	defer catalogsMu.Unlock()
+	c, ok := catalogs[ws.KrewRoot]
+	if !ok {
+		c = &pluginCatalog{root: ws.KrewRoot}
+		catalogs[ws.KrewRoot] = c
+	}
+	return c
+}
+
+// indexSignature summarizes the state of every index checkout, so a change
+// can be detected with a few stats instead of rereading all manifests.
+func indexSignature(root string) (string, error) {
+	dirs, err := os.ReadDir(filepath.Join(root, "index"))
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for _, d := range dirs {
+		dir := filepath.Join(root, "index", d.Name())
+		b.WriteString(d.Name())
+		for _, p := range []string{filepath.Join(dir, ".git", "index"), filepath.Join(dir, "plugins")} {
+			if fi, err := os.Stat(p); err == nil {
+				fmt.Fprintf(&b, ":%d:%d", fi.ModTime().UnixNano(), fi.Size())
+			}
+		}
+		b.WriteString(";")
+	}
+	return b.String(), nil
+}
+
+// refresh reloads the catalog if the indexes changed since the last load.
+func (c *pluginCatalog) refresh() error {
+	sig, err := indexSignature(c.root)
+	if err != nil {
+		return fmt.Errorf("read krew index: %w", err)
+	}
+	if sig == c.signature && c.byName != nil {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(c.root, "index", "*", "plugins", "*.yaml"))
+	if err != nil {
+		return err
+	}
+	plugins := make([]*indexPlugin, 0, len(files))
+	byName := make(map[string]*indexPlugin, len(files))
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		p := &indexPlugin{Index: filepath.Base(filepath.Dir(filepath.Dir(f))), File: f}
+		if err := yaml.Unmarshal(data, &p.Manifest); err != nil || p.Manifest.Metadata.Name == "" {
+			fmt.Fprintf(os.Stderr, "catalog: skipping invalid manifest %s: %v\n", f, err)
+			continue
+		}
+		plugins = append(plugins, p)
+		byName[p.qualifiedName()] = p
+	}
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].qualifiedName() < plugins[j].qualifiedName()
+	})
+
+	c.plugins = plugins
+	c.byName = byName
+	c.signature = sig
+	c.loadedAt = time.Now()
+	return nil
+}
+
+// list returns all plugins of all indexes, sorted by qualified name.
+func (c *pluginCatalog) list() ([]*indexPlugin, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	return c.plugins, nil
+}
+
+// get looks up a plugin by qualified name.
+func (c *pluginCatalog) get(name string) (*indexPlugin, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.refresh(); err != nil {
+		return nil, false, err
+	}
+	p, ok := c.byName[name]
+	return p, ok, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Categories are derived from the name, descriptions and homepage of a
+// plugin with keyword rules. Keywords match at the start of a word, so
+// "log" also matches "logs" and "logging". A plugin matching no rule is
+// in "other".
+
+const otherCategory = "other"
+
+// categoryRule puts plugins mentioning one of Keywords in Category.
+type categoryRule struct {
+	Category string   `yaml:"category" json:"category"`
+	Keywords []string `yaml:"keywords" json:"keywords"`
+}
+
+// pluginCategories is the categories file: rules added to the built-in
+// ones, and categories per plugin ("foo" or "index/foo") that replace the
+// derived ones.
+type pluginCategories struct {
+	Rules   []categoryRule      `yaml:"rules" json:"rules"`
+	Plugins map[string][]string `yaml:"plugins" json:"plugins"`
+}
+
+var defaultCategoryRules = []categoryRule{
+	{"logs", []string{"log", "tail", "event"}},
+	{"security", []string{"secur", "vulnerab", "cve", "scan", "audit", "secret", "cert", "tls", "encrypt", "sbom"}},
+	{"rbac", []string{"rbac", "role", "permission", "access", "who-can", "serviceaccount", "service account", "authoriz", "impersonat"}},
+	{"networking", []string{"network", "ingress", "dns", "port-forward", "port forward", "traffic", "proxy", "tcpdump", "packet", "sniff", "endpoint", "service mesh", "istio"}},
+	{"debugging", []string{"debug", "troubleshoot", "diagnos", "trace", "profil", "ssh", "exec", "shell", "crash", "core dump"}},
+	{"resource-views", []string{"tree", "view", "tabular", "overview", "tui", "dashboard", "capacity", "usage", "explore", "browse", "diff"}},
+	{"contexts", []string{"context", "namespace", "kubeconfig", "switch"}},
+	{"storage", []string{"storage", "volume", "pvc", "persistent", "backup", "snapshot", "restore"}},
+	{"workloads", []string{"deploy", "rollout", "restart", "scale", "job", "cronjob", "statefulset", "daemonset"}},
+	{"cluster-management", []string{"node", "cluster", "drain", "cordon", "upgrade", "operator", "crd", "api-resource", "deprecat", "cleanup", "clean up", "prune"}},
+}
+
+type compiledRule struct {
+	category string
+	pattern  *regexp.Regexp
+}
+
+var (
+	categoryRules     = compileCategoryRules(defaultCategoryRules)
+	categoryOverrides map[string][]string
+)
+
+func compileCategoryRules(rules []categoryRule) []compiledRule {
+	byCategory := make(map[string][]string)
+	var order []string
+	for _, r := range rules {
+		if _, ok := byCategory[r.Category]; !ok {
+			order = append(order, r.Category)
+		}
+		for _, k := range r.Keywords {
+			byCategory[r.Category] = append(byCategory[r.Category], regexp.QuoteMeta(strings.ToLower(k)))
+		}
+	}
+	compiled := make([]compiledRule, 0, len(order))
+	for _, c := range order {
+		if len(byCategory[c]) == 0 {
+			continue
+		}
+		compiled = append(compiled, compiledRule{
+			category: c,
+			pattern:  regexp.MustCompile(`\b(?:` + strings.Join(byCategory[c], "|") + `)`),
+		})
+	}
+	return compiled
+}
+
+// loadPluginCategories adds the rules of PLUGIN_CATEGORIES_FILE to the
+// built-in ones and takes its per-plugin categories as overrides.
+func loadPluginCategories() error {
+	file := os.Getenv("PLUGIN_CATEGORIES_FILE")
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read plugin categories: %w", err)
+	}
+	var pc pluginCategories
+	if err := yaml.Unmarshal(data, &pc); err != nil {
+		return fmt.Errorf("parse plugin categories %s: %w", file, err)
+	}
+	for _, r := range pc.Rules {
+		if r.Category == "" || len(r.Keywords) == 0 {
+			return fmt.Errorf("plugin categories %s: a rule needs a category and keywords", file)
+		}
+	}
+	overrides := make(map[string][]string, len(pc.Plugins))
+	for name, categories := range pc.Plugins {
+		if err := validPluginName(name); err != nil {
+			return fmt.Errorf("plugin categories %s: %w", file, err)
+		}
+		overrides[canonicalPluginName(name)] = categories
+	}
+	categoryRules = compileCategoryRules(append(append([]categoryRule(nil), defaultCategoryRules...), pc.Rules...))
+	categoryOverrides = overrides
+	return nil
+}
+
+// categorize returns the sorted categories of the plugin name with spec.
+func categorize(name string, spec pluginSpec) []string {
+	if categories, ok := categoryOverrides[name]; ok {
+		return categories
+	}
+	text := strings.ToLower(strings.Join([]string{name, spec.ShortDescription, spec.Description, spec.Homepage}, " "))
+	var categories []string
+	for _, r := range categoryRules {
+		if r.pattern.MatchString(text) {
+			categories = append(categories, r.category)
+		}
+	}
+	if len(categories) == 0 {
+		return []string{otherCategory}
+	}
+	sort.Strings(categories)
+	return categories
+}
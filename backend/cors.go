@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// corsPolicy controls which browser origins may call the API. The same
+// origin allowlist applies to the shell WebSocket.
+type corsPolicy struct {
+	Origins          []string
+	AllowCredentials bool
+	Methods          []string
+	Headers          []string
+	MaxAge           int
+}
+
+var activeCORSPolicy corsPolicy
+
+func envList(name string, def []string) []string {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	var list []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// loadCORSPolicy reads the policy from the environment. Origins default to
+// the origin of RANCHER_URL, where the UI extension is served from. Any
+// origin ("*") together with credentials is refused: the origin is echoed,
+// so every site could make credentialed requests.
+func loadCORSPolicy() error {
+	p := corsPolicy{
+		Origins:          envList("ALLOWED_ORIGINS", nil),
+		AllowCredentials: os.Getenv("CORS_ALLOW_CREDENTIALS") == "true",
+		Methods:          envList("CORS_ALLOWED_METHODS", []string{"GET", "POST", "DELETE", "OPTIONS"}),
+		Headers:          envList("CORS_ALLOWED_HEADERS", []string{"Origin", "Authorization", "Content-Type", "X-Rancher-Token"}),
+		MaxAge:           600,
+	}
+	for i, o := range p.Origins {
+		p.Origins[i] = strings.TrimRight(o, "/")
+	}
+	if len(p.Origins) == 0 {
+		if u, err := url.Parse(rancherURL()); err == nil {
+			p.Origins = []string{u.Scheme + "://" + u.Host}
+		}
+	}
+	if v, err := strconv.Atoi(os.Getenv("CORS_MAX_AGE")); err == nil && v >= 0 {
+		p.MaxAge = v
+	}
+	if p.AllowCredentials && containsString(p.Origins, "*") {
+		return fmt.Errorf("ALLOWED_ORIGINS=* cannot be combined with CORS_ALLOW_CREDENTIALS=true")
+	}
+	activeCORSPolicy = p
+	return nil
+}
+
+func (p corsPolicy) originAllowed(origin string) bool {
+	return containsString(p.Origins, "*") || p.originListed(origin)
+}
+
+// originListed reports whether origin is on the allowlist by name; "*" does
+// not count.
+func (p corsPolicy) originListed(origin string) bool {
+	origin = strings.TrimRight(origin, "/")
+	for _, o := range p.Origins {
+		if o != "*" && strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// middleware sets CORS headers for allowed origins and answers preflight
+// requests. The request origin is echoed rather than "*", so credentials
+// can be allowed safely.
+func (p corsPolicy) middleware() gin.HandlerFunc {
+	methods := strings.Join(p.Methods, ", ")
+	headers := strings.Join(p.Headers, ", ")
+	maxAge := strconv.Itoa(p.MaxAge)
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Origin")
+		if !p.originAllowed(origin) {
+			if c.Request.Method == "OPTIONS" {
+				c.AbortWithStatus(403)
+				return
+			}
+			c.Next()
+			return
+		}
+		h := c.Writer.Header()
+		h.Set("Access-Control-Allow-Origin", origin)
+		if p.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if c.Request.Method == "OPTIONS" {
+			h.Set("Access-Control-Allow-Methods", methods)
+			h.Set("Access-Control-Allow-Headers", headers)
+			h.Set("Access-Control-Max-Age", maxAge)
+			c.AbortWithStatus(204)
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		origins []string
+		origin  string
+		allowed bool
+		listed  bool
+	}{
+		{[]string{"https://rancher.example.com"}, "https://rancher.example.com", true, true},
+		{[]string{"https://rancher.example.com"}, "https://RANCHER.example.com/", true, true},
+		{[]string{"https://rancher.example.com"}, "http://rancher.example.com", false, false},
+		{[]string{"https://rancher.example.com"}, "https://rancher.example.com.evil.io", false, false},
+		{[]string{"https://rancher.example.com"}, "https://evil.io", false, false},
+		{[]string{"*"}, "https://evil.io", true, false},
+		{[]string{"*", "https://rancher.example.com"}, "https://rancher.example.com", true, true},
+		{nil, "https://rancher.example.com", false, false},
+	}
+	for _, tt := range tests {
+		p := corsPolicy{Origins: tt.origins}
+		if got := p.originAllowed(tt.origin); got != tt.allowed {
+			t.Errorf("%v: originAllowed(%q) = %v, want %v", tt.origins, tt.origin, got, tt.allowed)
+		}
+		if got := p.originListed(tt.origin); got != tt.listed {
+			t.Errorf("%v: originListed(%q) = %v, want %v", tt.origins, tt.origin, got, tt.listed)
+		}
+	}
+}
+
+func TestLoadCORSPolicy(t *testing.T) {
+	saved := activeCORSPolicy
+	t.Cleanup(func() { activeCORSPolicy = saved })
+	tests := []struct {
+		name        string
+		origins     string
+		credentials string
+		want        []string
+		wantErr     bool
+	}{
+		{"defaults to the Rancher origin", "", "", []string{"https://rancher.example.com"}, false},
+		{"list is trimmed", " https://a.example.com/ ,https://b.example.com", "", []string{"https://a.example.com", "https://b.example.com"}, false},
+		{"wildcard", "*", "false", []string{"*"}, false},
+		{"listed origins with credentials", "https://a.example.com", "true", []string{"https://a.example.com"}, false},
+		{"wildcard with credentials", "*", "true", nil, true},
+		{"wildcard among others with credentials", "https://a.example.com,*", "true", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("RANCHER_URL", "https://rancher.example.com/")
+			t.Setenv("ALLOWED_ORIGINS", tt.origins)
+			t.Setenv("CORS_ALLOW_CREDENTIALS", tt.credentials)
+			activeCORSPolicy = corsPolicy{}
+			err := loadCORSPolicy()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadCORSPolicy error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(activeCORSPolicy.Origins, tt.want) {
+				t.Errorf("origins = %v, want %v", activeCORSPolicy.Origins, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckWSOrigin(t *testing.T) {
+	saved := activeCORSPolicy
+	t.Cleanup(func() { activeCORSPolicy = saved })
+	tests := []struct {
+		origins []string
+		origin  string
+		want    bool
+	}{
+		{[]string{"https://rancher.example.com"}, "", true},
+		{[]string{"https://rancher.example.com"}, "https://rancher.example.com", true},
+		{[]string{"https://rancher.example.com"}, "https://workstation.example.com", true},
+		{[]string{"https://rancher.example.com"}, "https://evil.io", false},
+		{[]string{"*"}, "https://evil.io", false},
+		{[]string{"*"}, "https://workstation.example.com", true},
+	}
+	for _, tt := range tests {
+		activeCORSPolicy = corsPolicy{Origins: tt.origins}
+		r := httptest.NewRequest("GET", "https://workstation.example.com/api/shell", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := checkWSOrigin(r); got != tt.want {
+			t.Errorf("%v: checkWSOrigin(%q) = %v, want %v", tt.origins, tt.origin, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pluginDetail is everything the UI shows on a plugin's detail view.
+type pluginDetail struct {
+	Plugin
+	Files     []pluginFile `json:"files,omitempty"`
+	Help      string       `json:"help,omitempty"`
+	HelpError string       `json:"helpError,omitempty"`
+}
+
+type pluginFile struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	Mode string `json:"mode"`
+}
+
+// baseName strips the index prefix from a qualified plugin name.
+func baseName(name string) string {
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return name[i+1:]
+	}
+	return name
+}
+
+// pluginStoreDir returns $KREW_ROOT/store/<name>/<version>. Name and
+// version come from a receipt the workspace user can edit, so they have to
+// be plain file names, and the directory must not be reached through a
+// symlink: the backend reads it as root.
+func pluginStoreDir(ws *workspace, name, version string) (string, error) {
+	name = baseName(name)
+	for _, elem := range []string{name, version} {
+		if elem == "" || elem != filepath.Base(elem) || strings.HasPrefix(elem, ".") {
+			return "", fmt.Errorf("invalid plugin store path %q", elem)
+		}
+	}
+	dir := filepath.Join(ws.KrewRoot, "store", name, version)
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	if resolved != dir {
+		return "", fmt.Errorf("plugin store %s leads to %s", dir, resolved)
+	}
+	return dir, nil
+}
+
+// storeFiles lists the files krew unpacked for an installed plugin under
+// $KREW_ROOT/store/<name>/<version>, relative to that directory.
+func storeFiles(ws *workspace, p *installedPlugin) []pluginFile {
+	dir, err := pluginStoreDir(ws, p.Name, p.Version)
+	if err != nil {
+		return nil
+	}
+	var files []pluginFile
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, pluginFile{Path: rel, Size: info.Size(), Mode: info.Mode().String()})
+		return nil
+	})
+	return files
+}
+
+// pluginHelp runs `kubectl <plugin> --help` in the workspace.
+func pluginHelp(ctx context.Context, ws *workspace, name string) (string, error) {
+	timeout := operationTimeout("kubectl")
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := ws.commandContext(ctx, "kubectl", baseName(name), "--help")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(out), subprocessError(ctx, "kubectl "+baseName(name)+" --help", timeout, err)
+	}
+	return string(out), nil
+}
+
+// pluginDetails assembles the detail view of name from the index and the
+// receipt. ok is false when the plugin is neither in an index nor installed.
+func pluginDetails(ctx context.Context, ws *workspace, name string) (detail *pluginDetail, ok bool, err error) {
+	entry, inIndex, err := catalogFor(ws).get(name)
+	if err != nil {
+		return nil, false, err
+	}
+	installed, err := installedWithUpdates(ws)
+	if err != nil {
+		return nil, false, err
+	}
+	receipt, isInstalled := installed[name]
+	if !inIndex && !isInstalled {
+		return nil, false, nil
+	}
+
+	detail = &pluginDetail{}
+	if inIndex {
+		detail.Plugin = entry.plugin()
+	} else {
+		detail.Plugin = Plugin{Name: name, Index: receipt.Index}
+	}
+	if !isInstalled {
+		return detail, true, nil
+	}
+
+	detail.Installed = true
+	detail.Version = receipt.Version
+	detail.Receipt = receipt
+	detail.Upgradable = receipt.Upgradable
+	detail.PolicyViolation = receipt.PolicyViolation
+	detail.Files = storeFiles(ws, receipt)
+	help, err := pluginHelp(ctx, ws, name)
+	detail.Help = help
+	if err != nil {
+		detail.HelpError = err.Error()
+	}
+	return detail, true, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPluginStoreDir(t *testing.T) {
+	// The store path must be free of symlinks, so resolve the temp dir
+	// (on macOS it is under the /var symlink).
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws := &workspace{KrewRoot: filepath.Join(root, "krew")}
+	store := filepath.Join(ws.KrewRoot, "store")
+	outside := filepath.Join(root, "other")
+	for _, d := range []string{filepath.Join(store, "foo", "v1.0.0"), filepath.Join(store, "bar"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(store, "bar", "v1.0.0")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(store, "baz")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, version string
+		wantErr       bool
+	}{
+		{"foo", "v1.0.0", false},
+		{"index/foo", "v1.0.0", false},
+		{"foo", "v2.0.0", true},
+		{"foo", "", true},
+		{"foo", ".", true},
+		{"foo", "..", true},
+		{"foo", "../../other", true},
+		{"foo", "v1.0.0/../../../other", true},
+		{"..", "other", true},
+		{"bar", "v1.0.0", true},
+		{"baz", "v1.0.0", true},
+	}
+	for _, tt := range tests {
+		dir, err := pluginStoreDir(ws, tt.name, tt.version)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pluginStoreDir(%q, %q) = %q, %v; want error %v", tt.name, tt.version, dir, err, tt.wantErr)
+		}
+	}
+}
@@ -16,6 +16,10 @@ if ! command -v krew >/dev/null 2>&1; then
   rm -f "${KREW_TAR}" "krew-${OS}_${ARCH}"
 fi
 
+# Workspace shells run as unprivileged users: let them reach the shared krew
+# binary and completion scripts under /root without listing it.
+chmod 0711 /root
+
 # SSH key for node access (generate if not exists)
 mkdir -p /root/.ssh
 if [ ! -f /root/.ssh/id_rsa ]; then
@@ -35,18 +39,6 @@ if ! grep -q 'kubectl-completion' /root/.bashrc 2>/dev/null; then
   } >> /root/.bashrc
 fi
 
-# Update plugin index
-kubectl krew update 2>/dev/null || true
-
-# Install k9s and ssh-jump first (sync) — user expects them immediately
-kubectl krew list 2>/dev/null | grep -q "^k9s$" || kubectl krew install k9s 2>/dev/null || true
-kubectl krew list 2>/dev/null | grep -q "^ssh-jump$" || kubectl krew install ssh-jump 2>/dev/null || true
-
-# Install other default plugins in background
-(
-  for p in stern lineage get-all crust-gather; do
-    kubectl krew list 2>/dev/null | grep -q "^${p}$" || kubectl krew install "$p" 2>/dev/null || true
-  done
-) &
-
+# Default plugins are installed by the backend from the plugin profile
+# (PLUGIN_PROFILE_FILE), see GET /api/profile.
 exec krew-manager
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Installs and upgrades used to run `krew update` first every time. Now the
+// time of the last update is recorded per krew root, jobs skip the update
+// while the index is younger than INDEX_MAX_AGE, and a background loop keeps
+// the indexes of active workspaces fresh.
+
+const (
+	defaultIndexMaxAge          = time.Hour
+	defaultIndexRefreshInterval = 30 * time.Minute
+)
+
+// activeWorkspaces are the workspaces used since startup, by krew root;
+// the background refresh covers these and the shared root.
+var activeWorkspaces sync.Map
+
+func indexMaxAge() time.Duration {
+	return envDuration("INDEX_MAX_AGE", defaultIndexMaxAge)
+}
+
+func indexRefreshInterval() time.Duration {
+	return envDuration("INDEX_REFRESH_INTERVAL", defaultIndexRefreshInterval)
+}
+
+// envDuration reads a duration like "30m" from key; "0" is allowed.
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		fmt.Fprintf(os.Stderr, "ignoring invalid %s=%q\n", key, v)
+		return def
+	}
+	return d
+}
+
+func indexUpdateMarker(ws *workspace) string {
+	return filepath.Join(ws.KrewRoot, ".index-updated")
+}
+
+// markIndexUpdated records a successful `krew update` in ws.
+func markIndexUpdated(ws *workspace) {
+	if err := ws.writeFile(indexUpdateMarker(ws), nil, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "index marker: %v\n", err)
+	}
+}
+
+// indexUpdatedAt returns when the index of ws was last updated: the marker,
+// or the last fetch of the default index for updates run from a shell.
+// It is zero if the index was never fetched.
+func indexUpdatedAt(ws *workspace) time.Time {
+	var latest time.Time
+	for _, f := range []string{
+		indexUpdateMarker(ws),
+		filepath.Join(ws.KrewRoot, "index", defaultIndex, ".git", "FETCH_HEAD"),
+	} {
+		if fi, err := os.Stat(f); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
+
+// indexFresh reports whether the index of ws was updated within
+// INDEX_MAX_AGE, and how long ago.
+func indexFresh(ws *workspace) (bool, time.Duration) {
+	at := indexUpdatedAt(ws)
+	if at.IsZero() {
+		return false, 0
+	}
+	age := time.Since(at)
+	return age < indexMaxAge(), age
+}
+
+// updateIndexIfStale runs `krew update` in ws unless the index is fresh.
+// Like before, a failed update is only reported to emit.
+func updateIndexIfStale(ctx context.Context, ws *workspace, emit lineFunc) {
+	if fresh, age := indexFresh(ws); fresh {
+		emit(streamSystem, fmt.Sprintf("index updated %s ago, skipping krew update", age.Round(time.Second)))
+		return
+	}
+	runKrewStream(ctx, ws, emit, "update")
+}
+
+// refreshIndexes updates the stale indexes of the shared root and every
+// active workspace every INDEX_REFRESH_INTERVAL; 0 turns it off.
+func refreshIndexes() {
+	interval := indexRefreshInterval()
+	if interval == 0 {
+		return
+	}
+	for range time.Tick(interval) {
+		roots := []*workspace{sharedWorkspace()}
+		activeWorkspaces.Range(func(_, v interface{}) bool {
+			roots = append(roots, v.(*workspace))
+			return true
+		})
+		for _, ws := range roots {
+			if fresh, _ := indexFresh(ws); fresh {
+				continue
+			}
+			if err := runKrewStream(context.Background(), ws, discardLines, "update"); err != nil {
+				fmt.Fprintf(os.Stderr, "index refresh %s: %v\n", ws.KrewRoot, err)
+			}
+		}
+	}
+}
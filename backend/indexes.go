@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// krewIndex is one plugin index configured in a krew root.
+type krewIndex struct {
+	Name    string `json:"name"`
+	URL     string `json:"url"`
+	Default bool   `json:"default"`
+	Plugins int    `json:"plugins"`
+}
+
+// indexRemoteURL reads the origin URL of an index checkout from its git
+// config, which is what `krew index list` shows.
+func indexRemoteURL(dir string) string {
+	f, err := os.Open(filepath.Join(dir, ".git", "config"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	inOrigin := false
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") {
+			inOrigin = line == `[remote "origin"]`
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok && inOrigin && strings.TrimSpace(key) == "url" {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// listIndexes returns the indexes of ws with their plugin counts.
+func listIndexes(ws *workspace) ([]krewIndex, error) {
+	dirs, err := os.ReadDir(filepath.Join(ws.KrewRoot, "index"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []krewIndex{}, nil
+		}
+		return nil, err
+	}
+	entries, err := catalogFor(ws).list()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int)
+	for _, e := range entries {
+		counts[e.Index]++
+	}
+	indexes := []krewIndex{}
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		indexes = append(indexes, krewIndex{
+			Name:    d.Name(),
+			URL:     indexRemoteURL(filepath.Join(ws.KrewRoot, "index", d.Name())),
+			Default: d.Name() == defaultIndex,
+			Plugins: counts[d.Name()],
+		})
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i].Name < indexes[j].Name })
+	return indexes, nil
+}
+
+// validIndexURL accepts the git URLs krew can clone: http(s), ssh, git and
+// file URLs and scp-like "user@host:path". Nothing may look like a flag.
+func validIndexURL(raw string) error {
+	if raw == "" || strings.HasPrefix(raw, "-") || strings.ContainsAny(raw, " \t\r\n") {
+		return fmt.Errorf("invalid index URL %q", raw)
+	}
+	if u, err := url.Parse(raw); err == nil && u.Scheme != "" {
+		switch u.Scheme {
+		case "https", "http", "ssh", "git", "file":
+			return nil
+		}
+		if !strings.Contains(raw, "@") {
+			return fmt.Errorf("unsupported index URL scheme %q", u.Scheme)
+		}
+	}
+	if at, colon := strings.IndexByte(raw, '@'), strings.IndexByte(raw, ':'); at > 0 && colon > at {
+		return nil
+	}
+	return fmt.Errorf("invalid index URL %q", raw)
+}
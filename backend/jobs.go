@@ -0,0 +1,414 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Job states. queued and running are the only non-final ones.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCanceled  = "canceled"
+)
+
+// maxFinishedJobs bounds how many finished jobs are kept for inspection.
+const maxFinishedJobs = 200
+
+// maxQueuedJobs bounds how many jobs may wait for a worker.
+const maxQueuedJobs = 1024
+
+// jobFunc does the work of a job. Output passed to j.emit is captured for
+// the job transcript; the returned value is exposed as the job result.
+type jobFunc func(ctx context.Context, j *job) (interface{}, error)
+
+// job is one asynchronous plugin operation, e.g. `krew install foo`.
+type job struct {
+	ID        string     `json:"id"`
+	Kind      string     `json:"kind"`
+	Plugin    string     `json:"plugin,omitempty"`
+	UserID    string     `json:"userId"`
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"createdAt"`
+	StartedAt *time.Time `json:"startedAt,omitempty"`
+	EndedAt   *time.Time `json:"endedAt,omitempty"`
+	ExitCode  *int       `json:"exitCode,omitempty"`
+
+	// QueuePosition is what is ahead of this job: while queued, the jobs
+	// before it and the running job of its krew root; once running, the
+	// krew operations ahead of it while it waits for the krew lock.
+	QueuePosition int `json:"queuePosition,omitempty"`
+
+	Output string      `json:"output"`
+	Error  string      `json:"error,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+
+	Transcript []jobLine `json:"transcript,omitempty"`
+
+	mu      sync.Mutex
+	root    string // KREW_ROOT the job works on
+	run     jobFunc
+	ctx     context.Context
+	cancel  context.CancelFunc
+	lines   []jobLine
+	changed chan struct{} // closed and replaced whenever lines or status change
+}
+
+// emit appends a line to the job transcript and wakes up streams.
+func (j *job) emit(stream, text string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.lines = append(j.lines, jobLine{Time: time.Now(), Stream: stream, Text: text})
+	j.notify()
+}
+
+// notify wakes up everybody waiting on j.changed. j.mu must be held.
+func (j *job) notify() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// linesSince returns the transcript lines from cursor on, whether the job
+// has finished, and a channel that is closed on the next change.
+func (j *job) linesSince(cursor int) ([]jobLine, bool, <-chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var lines []jobLine
+	if cursor < len(j.lines) {
+		lines = append(lines, j.lines[cursor:]...)
+	}
+	return lines, j.finished(), j.changed
+}
+
+func (j *job) finished() bool {
+	return j.Status != jobQueued && j.Status != jobRunning
+}
+
+// snapshot returns a copy of the job that is safe to serialize.
+func (j *job) snapshot() *job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var output strings.Builder
+	for _, l := range j.lines {
+		output.WriteString(l.Text)
+		output.WriteByte('\n')
+	}
+	return &job{
+		ID: j.ID, Kind: j.Kind, Plugin: j.Plugin, UserID: j.UserID,
+		Status: j.Status, CreatedAt: j.CreatedAt, StartedAt: j.StartedAt, EndedAt: j.EndedAt,
+		ExitCode: j.ExitCode, QueuePosition: j.QueuePosition,
+		Output: output.String(), Error: j.Error, Result: j.Result,
+		Transcript: append([]jobLine(nil), j.lines...),
+	}
+}
+
+// jobQueue runs jobs on a fixed number of workers and keeps them around
+// for inspection after they finish. Jobs of one krew root run one at a
+// time, and a job only takes a worker once its root is free, so one user's
+// queued jobs cannot hold every worker while they wait for each other.
+type jobQueue struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	queued []*job          // waiting for a worker, oldest first
+	busy   map[string]bool // krew roots with a running job
+	wake   *sync.Cond      // signaled when a job is queued or a root frees up
+}
+
+var jobs = newJobQueue(envInt("JOB_WORKERS", 2))
+
+func newJobQueue(workers int) *jobQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	q := &jobQueue{
+		jobs: make(map[string]*job),
+		busy: make(map[string]bool),
+	}
+	q.wake = sync.NewCond(&q.mu)
+	for i := 0; i < workers; i++ {
+		go q.worker()
+	}
+	return q
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// submit queues run as a new job on the krew root root and returns it
+// immediately.
+func (q *jobQueue) submit(user *rancherUser, root, kind, plugin string, run jobFunc) (*job, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		ID:        newJobID(),
+		Kind:      kind,
+		Plugin:    plugin,
+		UserID:    user.ID,
+		Status:    jobQueued,
+		CreatedAt: time.Now(),
+		root:      root,
+		run:       run,
+		ctx:       ctx,
+		cancel:    cancel,
+		changed:   make(chan struct{}),
+	}
+	q.mu.Lock()
+	q.jobs[j.ID] = j
+	q.prune()
+	full := len(q.queued) >= maxQueuedJobs
+	if !full {
+		q.queued = append(q.queued, j)
+		q.reportPositions()
+		q.wake.Signal()
+	}
+	q.mu.Unlock()
+
+	if full {
+		q.finish(j, nil, errors.New("job queue is full"))
+		return nil, fmt.Errorf("job queue is full")
+	}
+	return j.snapshot(), nil
+}
+
+// next waits for the oldest queued job whose krew root is free, marks the
+// root busy and takes the job off the queue. Canceled jobs are dropped.
+func (q *jobQueue) next() *job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		q.dropFinished()
+		for i, j := range q.queued {
+			if q.busy[j.root] {
+				continue
+			}
+			q.queued = append(q.queued[:i], q.queued[i+1:]...)
+			q.busy[j.root] = true
+			q.reportPositions()
+			return j
+		}
+		q.wake.Wait()
+	}
+}
+
+// done frees the krew root of j for the next job.
+func (q *jobQueue) done(j *job) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.busy, j.root)
+	q.reportPositions()
+	q.wake.Broadcast()
+}
+
+// dropFinished removes canceled jobs from the queue. q.mu must be held.
+func (q *jobQueue) dropFinished() {
+	queued := q.queued[:0]
+	for _, j := range q.queued {
+		j.mu.Lock()
+		if !j.finished() {
+			queued = append(queued, j)
+		}
+		j.mu.Unlock()
+	}
+	q.queued = queued
+}
+
+// reportPositions updates QueuePosition of the queued jobs: the jobs before
+// each one, plus the running job of its krew root. q.mu must be held.
+func (q *jobQueue) reportPositions() {
+	for i, j := range q.queued {
+		ahead := i
+		if q.busy[j.root] {
+			ahead++
+		}
+		j.mu.Lock()
+		if j.QueuePosition != ahead {
+			j.QueuePosition = ahead
+			j.notify()
+		}
+		j.mu.Unlock()
+	}
+}
+
+func (q *jobQueue) worker() {
+	for {
+		j := q.next()
+		j.mu.Lock()
+		if j.Status != jobQueued {
+			j.mu.Unlock()
+			q.done(j)
+			continue
+		}
+		now := time.Now()
+		j.Status = jobRunning
+		j.StartedAt = &now
+		j.QueuePosition = 0
+		j.mu.Unlock()
+
+		ctx := withQueueReporter(j.ctx, func(ahead int) {
+			j.mu.Lock()
+			j.QueuePosition = ahead
+			j.notify()
+			j.mu.Unlock()
+			if ahead > 0 {
+				j.emit(streamSystem, fmt.Sprintf("waiting for %d krew operation(s) ahead", ahead))
+			}
+		})
+		result, err := j.run(ctx, j)
+		q.finish(j, result, err)
+		q.done(j)
+	}
+}
+
+// finish records the outcome of j. Exit codes are taken from the krew
+// process when the error carries one.
+func (q *jobQueue) finish(j *job, result interface{}, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.EndedAt = &now
+	j.Result = result
+	var exitErr *exec.ExitError
+	switch {
+	case j.ctx.Err() == context.Canceled:
+		j.Status = jobCanceled
+		j.Error = "canceled"
+	case err != nil:
+		j.Status = jobFailed
+		j.Error = err.Error()
+	default:
+		j.Status = jobSucceeded
+	}
+	if errors.As(err, &exitErr) {
+		code := exitErr.ExitCode()
+		j.ExitCode = &code
+	} else if err == nil {
+		code := 0
+		j.ExitCode = &code
+	}
+	j.notify()
+	j.cancel()
+}
+
+// prune drops the oldest finished jobs beyond maxFinishedJobs. q.mu must
+// be held.
+func (q *jobQueue) prune() {
+	var done []*job
+	for _, j := range q.jobs {
+		j.mu.Lock()
+		if j.finished() {
+			done = append(done, j)
+		}
+		j.mu.Unlock()
+	}
+	if len(done) <= maxFinishedJobs {
+		return
+	}
+	sort.Slice(done, func(a, b int) bool { return done[a].CreatedAt.Before(done[b].CreatedAt) })
+	for _, j := range done[:len(done)-maxFinishedJobs] {
+		delete(q.jobs, j.ID)
+	}
+}
+
+// lookup returns the live job id if it belongs to userID.
+func (q *jobQueue) lookup(userID, id string) (*job, bool) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	q.mu.Unlock()
+	if !ok || j.UserID != userID {
+		return nil, false
+	}
+	return j, true
+}
+
+// get returns a snapshot of job id if it belongs to userID.
+func (q *jobQueue) get(userID, id string) (*job, bool) {
+	j, ok := q.lookup(userID, id)
+	if !ok {
+		return nil, false
+	}
+	return j.snapshot(), true
+}
+
+// list returns snapshots of the jobs of userID, newest first, without
+// their output.
+func (q *jobQueue) list(userID string) []*job {
+	q.mu.Lock()
+	var mine []*job
+	for _, j := range q.jobs {
+		if j.UserID == userID {
+			mine = append(mine, j)
+		}
+	}
+	q.mu.Unlock()
+
+	list := make([]*job, 0, len(mine))
+	for _, j := range mine {
+		s := j.snapshot()
+		s.Output = ""
+		s.Transcript = nil
+		list = append(list, s)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].CreatedAt.After(list[b].CreatedAt) })
+	return list
+}
+
+// cancelJob stops job id: a queued job never starts, a running one has its
+// krew process killed.
+func (q *jobQueue) cancelJob(userID, id string) (*job, bool) {
+	j, ok := q.lookup(userID, id)
+	if !ok {
+		return nil, false
+	}
+	j.mu.Lock()
+	if j.Status == jobQueued {
+		j.Status = jobCanceled
+		j.Error = "canceled"
+		now := time.Now()
+		j.EndedAt = &now
+		j.notify()
+	}
+	j.mu.Unlock()
+	j.cancel()
+	q.mu.Lock()
+	q.dropFinished()
+	q.reportPositions()
+	q.mu.Unlock()
+	return j.snapshot(), true
+}
+
+// startJob submits run on the workspace ws for the user of the request and
+// answers 202 with the queued job.
+func startJob(c *gin.Context, ws *workspace, kind, plugin string, run jobFunc) {
+	user := c.MustGet("user").(*rancherUser)
+	j, err := jobs.submit(user, ws.KrewRoot, kind, plugin, run)
+	if err != nil {
+		c.JSON(503, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(202, gin.H{"jobId": j.ID, "job": j})
+}
+
+// krewJob returns a jobFunc that runs one krew command in ws, optionally
+// after refreshing a stale index (whose failure is not fatal, as before).
+func krewJob(ws *workspace, updateFirst bool, args ...string) jobFunc {
+	return func(ctx context.Context, j *job) (interface{}, error) {
+		if updateFirst {
+			updateIndexIfStale(ctx, ws, j.emit)
+		}
+		return nil, runKrewStream(ctx, ws, j.emit, args...)
+	}
+}
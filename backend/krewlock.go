@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// Krew does not guard KREW_ROOT against concurrent writers, so every
+// state-changing krew command on one root goes through a FIFO queue.
+// Read-only commands (list, search, info, index list) bypass it.
+
+// krewQueue is the FIFO of mutating krew commands for one KREW_ROOT. The
+// head of waiters holds the lock.
+type krewQueue struct {
+	mu      sync.Mutex
+	waiters []*krewWaiter
+	changed chan struct{} // closed and replaced whenever a waiter leaves
+}
+
+type krewWaiter struct {
+	ctx context.Context
+}
+
+var (
+	krewQueuesMu sync.Mutex
+	krewQueues   = make(map[string]*krewQueue)
+)
+
+func krewQueueFor(root string) *krewQueue {
+	krewQueuesMu.Lock()
+	defer krewQueuesMu.Unlock()
+	q, ok := krewQueues[root]
+	if !ok {
+		q = &krewQueue{changed: make(chan struct{})}
+		krewQueues[root] = q
+	}
+	return q
+}
+
+// krewMutates reports whether a krew command changes KREW_ROOT.
+func krewMutates(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	switch args[0] {
+	case "update", "install", "upgrade", "uninstall":
+		return true
+	case "index":
+		return len(args) > 1 && (args[1] == "add" || args[1] == "remove")
+	}
+	return false
+}
+
+type queueReporterKey struct{}
+
+// queueReporter is told the number of operations ahead while a caller waits
+// for the krew lock, and 0 once it holds it.
+type queueReporter func(ahead int)
+
+func withQueueReporter(ctx context.Context, r queueReporter) context.Context {
+	return context.WithValue(ctx, queueReporterKey{}, r)
+}
+
+func reportQueue(ctx context.Context, ahead int) {
+	if r, ok := ctx.Value(queueReporterKey{}).(queueReporter); ok {
+		r(ahead)
+	}
+}
+
+// lockKrew takes the krew lock of ws for a mutating command and is a no-op
+// for read-only ones.
+func lockKrew(ctx context.Context, ws *workspace, args []string) (release func(), err error) {
+	if !krewMutates(args) {
+		return func() {}, nil
+	}
+	return krewQueueFor(ws.KrewRoot).acquire(ctx)
+}
+
+// position returns how many waiters are ahead of w. q.mu must be held.
+func (q *krewQueue) position(w *krewWaiter) int {
+	for i, o := range q.waiters {
+		if o == w {
+			return i
+		}
+	}
+	return -1
+}
+
+// acquire waits until it is the caller's turn, reporting the queue position
+// through ctx as it advances. The returned release must be called when done.
+func (q *krewQueue) acquire(ctx context.Context) (release func(), err error) {
+	w := &krewWaiter{ctx: ctx}
+	q.mu.Lock()
+	q.waiters = append(q.waiters, w)
+	q.mu.Unlock()
+
+	release = func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		if pos := q.position(w); pos >= 0 {
+			q.waiters = append(q.waiters[:pos], q.waiters[pos+1:]...)
+			close(q.changed)
+			q.changed = make(chan struct{})
+		}
+	}
+
+	last := -1
+	for {
+		q.mu.Lock()
+		ahead, changed := q.position(w), q.changed
+		q.mu.Unlock()
+		if ahead != last {
+			reportQueue(ctx, ahead)
+			last = ahead
+		}
+		if ahead == 0 {
+			return release, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// The local index is a git-backed krew index hosted by the backend for
+// internal plugins. Manifests live in <dir>/repo/plugins, archives in
+// <dir>/archives/<sha256>/<file>. Krew downloads archives over HTTP, so
+// they are served to processes in the pod under /local-index/archives.
+
+const manifestAPIVersion = "krew.googlecontainertools.github.com/v1alpha2"
+
+var (
+	localIndexMu  sync.Mutex
+	sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+	// localIndexRegistered holds the krew roots the local index was added to (or
+	// failed to be added to) since it last changed, so workspaces do not
+	// retry on every request.
+	localIndexRegistered sync.Map
+)
+
+// localIndexDir is where the local index is kept, on the workspaces volume
+// by default so it survives restarts.
+func localIndexDir() string {
+	if d := os.Getenv("LOCAL_INDEX_DIR"); d != "" {
+		return d
+	}
+	return filepath.Join(workspacesDir(), ".local-index")
+}
+
+// localIndexName is the name the local index is registered under in krew.
+func localIndexName() string {
+	if n := os.Getenv("LOCAL_INDEX_NAME"); n != "" {
+		return n
+	}
+	return "local"
+}
+
+func localIndexRepo() string {
+	return filepath.Join(localIndexDir(), "repo")
+}
+
+// localIndexArchiveURL is the URL krew downloads an archive from.
+func localIndexArchiveURL(sum, file string) string {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "3000"
+	}
+	return fmt.Sprintf("http://127.0.0.1:%s/local-index/archives/%s/%s", port, sum, url.PathEscape(file))
+}
+
+// localIndexPublished reports whether the local index has a commit krew can
+// clone.
+func localIndexPublished() bool {
+	_, err := os.Stat(filepath.Join(localIndexRepo(), ".git", "refs", "heads", "master"))
+	return err == nil
+}
+
+// registerLocalIndex adds the local index to the krew root of w once it
+// has something to offer. A user index of the same name is left alone.
+func registerLocalIndex(w *workspace) error {
+	if !localIndexPublished() {
+		return nil
+	}
+	if _, done := localIndexRegistered.LoadOrStore(w.KrewRoot, true); done {
+		return nil
+	}
+	if _, err := os.Stat(filepath.Join(w.KrewRoot, "index", localIndexName())); err == nil {
+		return nil
+	}
+	if out, err := runKrew(w, "index", "add", localIndexName(), "file://"+localIndexRepo()); err != nil {
+		return fmt.Errorf("register local index: %w\n%s", err, out)
+	}
+	return nil
+}
+
+// localIndexGit runs git in the local index repository as the backend user.
+func localIndexGit(args ...string) error {
+	cmd := exec.Command("git", append([]string{"-C", localIndexRepo()}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=krew-workstation", "GIT_AUTHOR_EMAIL=krew-workstation@localhost",
+		"GIT_COMMITTER_NAME=krew-workstation", "GIT_COMMITTER_EMAIL=krew-workstation@localhost",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %w\n%s", strings.Join(args, " "), err, out)
+	}
+	return nil
+}
+
+// initLocalIndex creates the repository on first use. Everything is world
+// readable so workspace users can clone it and fetch archives.
+func initLocalIndex() error {
+	for _, d := range []string{filepath.Join(localIndexRepo(), "plugins"), filepath.Join(localIndexDir(), "archives")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(filepath.Join(localIndexRepo(), ".git")); err == nil {
+		return nil
+	}
+	return localIndexGit("init", "-q", "-b", "master")
+}
+
+// localIndexEntry is one plugin of the local index.
+type localIndexEntry struct {
+	Name             string   `json:"name"`
+	Version          string   `json:"version"`
+	ShortDescription string   `json:"shortDescription"`
+	Archives         []string `json:"archives"`
+}
+
+// listLocalIndex returns the plugins of the local index, sorted by name.
+func listLocalIndex() ([]localIndexEntry, error) {
+	files, err := filepath.Glob(filepath.Join(localIndexRepo(), "plugins", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	entries := []localIndexEntry{}
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var m pluginManifest
+		if err := yaml.Unmarshal(data, &m); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", f, err)
+		}
+		e := localIndexEntry{Name: m.Metadata.Name, Version: m.Spec.Version, ShortDescription: m.Spec.ShortDescription}
+		for _, p := range m.Spec.Platforms {
+			file := filepath.Base(p.URI)
+			if f, err := url.PathUnescape(file); err == nil {
+				file = f
+			}
+			e.Archives = append(e.Archives, file)
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// uploadedArchive is an archive sent along with a manifest.
+type uploadedArchive struct {
+	Name   string
+	SHA256 string
+	Data   []byte
+}
+
+// validArchiveFile reports whether file can be stored and served as an
+// archive: a plain file name that is not hidden.
+func validArchiveFile(file string) bool {
+	return file != "" && file == filepath.Base(file) && !strings.HasPrefix(file, ".")
+}
+
+// checkArchive accepts the formats krew unpacks: gzipped tarballs and zips.
+func checkArchive(a uploadedArchive) error {
+	if !validArchiveFile(a.Name) {
+		return fmt.Errorf("invalid archive file name %q", a.Name)
+	}
+	if _, err := gzip.NewReader(bytes.NewReader(a.Data)); err == nil {
+		return nil
+	}
+	if _, err := zip.NewReader(bytes.NewReader(a.Data), int64(len(a.Data))); err == nil {
+		return nil
+	}
+	return fmt.Errorf("archive %s is neither a .tar.gz nor a .zip", a.Name)
+}
+
+// validateSelector checks a platform selector the way krew evaluates it.
+func validateSelector(s *labelSelector) error {
+	if s == nil || (len(s.MatchLabels) == 0 && len(s.MatchExpressions) == 0) {
+		return fmt.Errorf("selector is required")
+	}
+	for _, req := range s.MatchExpressions {
+		if req.Key == "" {
+			return fmt.Errorf("matchExpressions: key is required")
+		}
+		switch req.Operator {
+		case "In", "NotIn":
+			if len(req.Values) == 0 {
+				return fmt.Errorf("matchExpressions %s: %s needs values", req.Key, req.Operator)
+			}
+		case "Exists", "DoesNotExist":
+			if len(req.Values) > 0 {
+				return fmt.Errorf("matchExpressions %s: %s takes no values", req.Key, req.Operator)
+			}
+		default:
+			return fmt.Errorf("matchExpressions %s: unknown operator %q", req.Key, req.Operator)
+		}
+	}
+	return nil
+}
+
+// validateManifest checks m and matches its platforms to archives by
+// sha256. It returns the archive of each platform.
+func validateManifest(m *pluginManifest, archives []uploadedArchive) ([]uploadedArchive, error) {
+	if m.APIVersion != manifestAPIVersion || m.Kind != "Plugin" {
+		return nil, fmt.Errorf("manifest must be apiVersion %s, kind Plugin", manifestAPIVersion)
+	}
+	name := m.Metadata.Name
+	if !pluginNamePattern.MatchString(name) || name == "krew" {
+		return nil, fmt.Errorf("invalid plugin name %q", name)
+	}
+	if !strings.HasPrefix(m.Spec.Version, "v") {
+		return nil, fmt.Errorf("version %q must be semver with a leading v", m.Spec.Version)
+	}
+	if _, _, ok := parseVersion(m.Spec.Version); !ok {
+		return nil, fmt.Errorf("version %q is not semver", m.Spec.Version)
+	}
+	if strings.TrimSpace(m.Spec.ShortDescription) == "" {
+		return nil, fmt.Errorf("shortDescription is required")
+	}
+	if len(m.Spec.Platforms) == 0 {
+		return nil, fmt.Errorf("at least one platform is required")
+	}
+	bySum := make(map[string]uploadedArchive, len(archives))
+	for _, a := range archives {
+		bySum[a.SHA256] = a
+	}
+	used := make(map[string]bool)
+	matched := make([]uploadedArchive, len(m.Spec.Platforms))
+	for i, p := range m.Spec.Platforms {
+		if err := validateSelector(p.Selector); err != nil {
+			return nil, fmt.Errorf("platform %d: %w", i, err)
+		}
+		if p.Bin == "" {
+			return nil, fmt.Errorf("platform %d: bin is required", i)
+		}
+		sum := p.SHA256
+		if !sha256Pattern.MatchString(sum) {
+			return nil, fmt.Errorf("platform %d: invalid sha256 %q", i, p.SHA256)
+		}
+		a, ok := bySum[sum]
+		if !ok {
+			return nil, fmt.Errorf("platform %d: sha256 %s does not match any uploaded archive", i, sum)
+		}
+		matched[i] = a
+		used[sum] = true
+	}
+	for _, a := range archives {
+		if !used[a.SHA256] {
+			return nil, fmt.Errorf("archive %s (sha256 %s) is not used by any platform", a.Name, a.SHA256)
+		}
+	}
+	return matched, nil
+}
+
+// publishLocalPlugin validates a manifest with its archives, stores the
+// archives, points the platforms at them and commits the manifest.
+func publishLocalPlugin(manifest []byte, archives []uploadedArchive, author string) (*pluginManifest, error) {
+	var m pluginManifest
+	if err := yaml.Unmarshal(manifest, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	for i := range archives {
+		sum := sha256.Sum256(archives[i].Data)
+		archives[i].SHA256 = hex.EncodeToString(sum[:])
+		if err := checkArchive(archives[i]); err != nil {
+			return nil, err
+		}
+	}
+	matched, err := validateManifest(&m, archives)
+	if err != nil {
+		return nil, err
+	}
+
+	localIndexMu.Lock()
+	defer localIndexMu.Unlock()
+	if err := initLocalIndex(); err != nil {
+		return nil, err
+	}
+	// Re-encode the raw document so fields this backend does not model
+	// (e.g. files) are kept as uploaded.
+	var doc yaml.Node
+	if err := yaml.Unmarshal(manifest, &doc); err != nil {
+		return nil, err
+	}
+	platforms := yamlPath(&doc, "spec", "platforms")
+	for i, a := range matched {
+		dir := filepath.Join(localIndexDir(), "archives", a.SHA256)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(dir, a.Name), a.Data, 0644); err != nil {
+			return nil, err
+		}
+		uri := localIndexArchiveURL(a.SHA256, a.Name)
+		m.Spec.Platforms[i].URI = uri
+		if platforms != nil && i < len(platforms.Content) {
+			setYAMLField(platforms.Content[i], "uri", uri)
+		}
+	}
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	rel := filepath.Join("plugins", m.Metadata.Name+".yaml")
+	if err := os.WriteFile(filepath.Join(localIndexRepo(), rel), out.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	if err := localIndexGit("add", rel); err != nil {
+		return nil, err
+	}
+	msg := fmt.Sprintf("Publish %s %s (uploaded by %s)", m.Metadata.Name, m.Spec.Version, author)
+	if err := localIndexGit("commit", "-q", "--allow-empty", "-m", msg); err != nil {
+		return nil, err
+	}
+	localIndexRegistered.Range(func(root, _ interface{}) bool {
+		localIndexRegistered.Delete(root)
+		return true
+	})
+	return &m, nil
+}
+
+// removeLocalPlugin deletes the manifest of name from the local index.
+// Archives stay, since receipts of installed copies may refer to them.
+func removeLocalPlugin(name, author string) (bool, error) {
+	localIndexMu.Lock()
+	defer localIndexMu.Unlock()
+	rel := filepath.Join("plugins", name+".yaml")
+	if _, err := os.Stat(filepath.Join(localIndexRepo(), rel)); err != nil {
+		return false, nil
+	}
+	if err := localIndexGit("rm", "-q", rel); err != nil {
+		return true, err
+	}
+	return true, localIndexGit("commit", "-q", "-m", fmt.Sprintf("Remove %s (by %s)", name, author))
+}
+
+// yamlPath walks mapping keys from the document root.
+func yamlPath(n *yaml.Node, keys ...string) *yaml.Node {
+	if n.Kind == yaml.DocumentNode && len(n.Content) > 0 {
+		n = n.Content[0]
+	}
+	for _, k := range keys {
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Value == k {
+				next = n.Content[i+1]
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return n
+}
+
+// setYAMLField sets key of mapping n to a string value.
+func setYAMLField(n *yaml.Node, key, value string) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			n.Content[i+1].SetString(value)
+			return
+		}
+	}
+	n.Content = append(n.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Value: value})
+}
+
+// serveLocalArchive serves archives to krew running in the pod. It is not
+// behind a session, so it only answers loopback clients.
+func serveLocalArchive(c *gin.Context) {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+		c.JSON(403, gin.H{"error": "local index archives are only served inside the pod"})
+		return
+	}
+	sum, file := c.Param("sha256"), c.Param("file")
+	if !sha256Pattern.MatchString(sum) || !validArchiveFile(file) {
+		c.JSON(404, gin.H{"error": "archive not found"})
+		return
+	}
+	path := filepath.Join(localIndexDir(), "archives", sum, file)
+	f, err := os.Open(path)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "archive not found"})
+		return
+	}
+	defer f.Close()
+	c.Header("Content-Type", "application/octet-stream")
+	c.Status(200)
+	io.Copy(c.Writer, f)
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestValidArchiveFile(t *testing.T) {
+	tests := []struct {
+		file  string
+		valid bool
+	}{
+		{"foo.tar.gz", true},
+		{"foo_linux-amd64.zip", true},
+		{"foo bar.tar.gz", true},
+		{"foo%2F..tar.gz", true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{".hidden.tar.gz", false},
+		{"../foo.tar.gz", false},
+		{"dir/foo.tar.gz", false},
+		{"/foo.tar.gz", false},
+		{"foo.tar.gz/", false},
+	}
+	for _, tt := range tests {
+		if got := validArchiveFile(tt.file); got != tt.valid {
+			t.Errorf("validArchiveFile(%q) = %v, want %v", tt.file, got, tt.valid)
+		}
+	}
+}
+
+func TestLocalIndexArchiveURL(t *testing.T) {
+	t.Setenv("PORT", "3000")
+	sum := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		file, want string
+	}{
+		{"foo.tar.gz", "http://127.0.0.1:3000/local-index/archives/" + sum + "/foo.tar.gz"},
+		{"foo bar.tar.gz", "http://127.0.0.1:3000/local-index/archives/" + sum + "/foo%20bar.tar.gz"},
+		{"foo?x#y.zip", "http://127.0.0.1:3000/local-index/archives/" + sum + "/foo%3Fx%23y.zip"},
+	}
+	for _, tt := range tests {
+		if got := localIndexArchiveURL(sum, tt.file); got != tt.want {
+			t.Errorf("localIndexArchiveURL(%q) = %q, want %q", tt.file, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -25,12 +26,51 @@ type Plugin struct {
 	Version     string `json:"version"`
 	Description string `json:"description"`
 	Installed   bool   `json:"installed"`
+	Upgradable  bool   `json:"upgradable"`
+
+	// Receipt of the installed plugin, nil when not installed
+	Receipt *installedPlugin `json:"receipt,omitempty"`
+
+	// Manifest data from the krew index
+	Index            string           `json:"index"`
+	AvailableVersion string           `json:"availableVersion"`
+	Homepage         string           `json:"homepage,omitempty"`
+	LongDescription  string           `json:"longDescription,omitempty"`
+	Caveats          string           `json:"caveats,omitempty"`
+	Platforms        []pluginPlatform `json:"platforms,omitempty"`
+
+	// Derived from the manifest by keyword rules, see categorize
+	Categories []string `json:"categories"`
+
+	// Set when the plugin policy forbids installing the index version
+	Blocked       bool   `json:"blocked"`
+	BlockedReason string `json:"blockedReason,omitempty"`
+
+	// Set when the policy forbids the installed version
+	PolicyViolation string `json:"policyViolation,omitempty"`
 }
 
 type PluginsResponse struct {
 	Plugins        []Plugin `json:"plugins"`
 	TerminalOutput string   `json:"terminalOutput,omitempty"`
 	Error          string   `json:"error,omitempty"`
+
+	// Plugins matching the query, and the page returned of them
+	Total    int `json:"total"`
+	Page     int `json:"page,omitempty"`
+	PageSize int `json:"pageSize,omitempty"`
+
+	// Counts per category and index for grouping and filtering
+	Facets *pluginFacets `json:"facets,omitempty"`
+
+	// When the index was last updated; unset if it never was.
+	IndexUpdatedAt  *time.Time `json:"indexUpdatedAt,omitempty"`
+	IndexAgeSeconds int64      `json:"indexAgeSeconds,omitempty"`
+	IndexStale      bool       `json:"indexStale"`
+
+	// Installed plugins whose version the policy forbids, in an index or
+	// not. The policy only guards the API; krew in the shell bypasses it.
+	PolicyViolations []*installedPlugin `json:"policyViolations,omitempty"`
 }
 
 type Cluster struct {
@@ -172,49 +212,36 @@ func krewRoot() string {
 	return filepath.Join(home, ".krew")
 }
 
-// runKrew runs a krew command. Krew plugins are installed globally on the
-// machine, they are NOT per-cluster. A kubeconfig is only needed when
-// *running* a plugin, not when installing/uninstalling/searching.
-func runKrew(args ...string) (string, error) {
-	root := krewRoot()
-	cmdArgs := append([]string{"krew"}, args...)
-	cmd := exec.Command("kubectl", cmdArgs...)
-	cmd.Env = append(os.Environ(),
-		"KREW_ROOT="+root,
-		fmt.Sprintf("PATH=%s:%s", filepath.Join(root, "bin"), os.Getenv("PATH")),
-	)
+// runKrew runs a krew command in the user's workspace. Krew plugins are
+// installed per workspace, they are NOT per-cluster. A kubeconfig is only
+// needed when *running* a plugin, not when installing/uninstalling/searching.
+func runKrew(ws *workspace, args ...string) (string, error) {
+	return runKrewContext(context.Background(), ws, args...)
+}
 
-	out, err := cmd.CombinedOutput()
-	output := string(out)
+// runKrewContext is runKrew with a context that kills krew when done.
+func runKrewContext(ctx context.Context, ws *workspace, args ...string) (string, error) {
+	what := "krew " + strings.Join(args, " ")
+	release, err := lockKrew(ctx, ws, args)
 	if err != nil {
-		return output, fmt.Errorf("krew %s failed: %w\n%s", strings.Join(args, " "), err, output)
+		return "", fmt.Errorf("%s: %w", what, err)
 	}
-	return output, nil
-}
+	defer release()
 
-func parseInstalledPlugins(output string) map[string]string {
-	installed := make(map[string]string)
-	for _, line := range strings.Split(output, "\n") {
-		fields := strings.Fields(line)
-		if len(fields) >= 2 && fields[0] != "PLUGIN" {
-			installed[fields[0]] = fields[1]
-		}
-	}
-	return installed
-}
+	timeout := operationTimeout(krewOperation(args))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := ws.commandContext(ctx, "kubectl", append([]string{"krew"}, args...)...)
 
-func parseAvailablePlugins(output string) []Plugin {
-	var plugins []Plugin
-	for _, line := range strings.Split(output, "\n") {
-		fields := strings.Fields(line)
-		if len(fields) < 2 || fields[0] == "NAME" {
-			continue
-		}
-		name := fields[0]
-		desc := strings.Join(fields[1:], " ")
-		plugins = append(plugins, Plugin{Name: name, Description: desc})
+	out, err := cmd.CombinedOutput()
+	output := string(out)
+	if err != nil {
+		return output, fmt.Errorf("%w\n%s", subprocessError(ctx, what, timeout, err), output)
 	}
-	return plugins
+	if krewOperation(args) == "update" {
+		markIndexUpdated(ws)
+	}
+	return output, nil
 }
 
 // kubeconfig structures for merging
@@ -323,11 +350,6 @@ func rewriteKubeconfigServerURLs(cfg *kubeConfig) {
 	}
 }
 
-func kubeConfigPath() string {
-	home, _ := os.UserHomeDir()
-	return filepath.Join(home, ".kube", "config")
-}
-
 // detectCLIs returns which container/runtime CLIs are installed.
 func detectCLIs() []string {
 	clis := []string{"crictl", "runc", "etcdctl", "zellij", "ssh"}
@@ -340,18 +362,11 @@ func detectCLIs() []string {
 	return found
 }
 
-// fetchWelcome runs kk list in background and returns formatted output.
-func fetchWelcome() string {
-	var listOut string
-	done := make(chan struct{})
-	go func() {
-		listOut, _ = runKrew("list")
-		close(done)
-	}()
-	select {
-	case <-done:
-	case <-time.After(5 * time.Second):
-	}
+// fetchWelcome runs kk list (for at most 5s) and returns formatted output.
+func fetchWelcome(ctx context.Context, ws *workspace) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	listOut, _ := runKrewContext(ctx, ws, "list")
 
 	clis := detectCLIs()
 
@@ -397,54 +412,79 @@ func parsePluginNames(listOut string) []string {
 	return names
 }
 
-func runKubectlConfig(args ...string) (string, error) {
-	kubeCfg := kubeConfigPath()
-	cmd := exec.Command("kubectl", append([]string{"config"}, args...)...)
-	cmd.Env = append(os.Environ(), "KUBECONFIG="+kubeCfg)
+func runKubectlConfig(ctx context.Context, ws *workspace, args ...string) (string, error) {
+	timeout := operationTimeout("kubectl")
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := ws.commandContext(ctx, "kubectl", append([]string{"config"}, args...)...)
 	out, err := cmd.CombinedOutput()
-	return string(out), err
+	if err != nil {
+		return string(out), subprocessError(ctx, "kubectl config "+strings.Join(args, " "), timeout, err)
+	}
+	return string(out), nil
 }
 
+// mustLoad runs the startup loaders in order and exits on the first error:
+// a broken policy file must not leave the backend running without it.
+func mustLoad(loaders ...func() error) {
+	for _, load := range loaders {
+		if err := load(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
 
 func main() {
+	mustLoad(loadRBACPolicy, loadCORSPolicy, loadPluginPolicy, loadPluginCategories)
+	// krew itself comes with the image, so what is in the shared root at
+	// startup is the baseline of its audit.
+	if err := recordPluginHashes(sharedWorkspace(), false); err != nil {
+		fmt.Fprintf(os.Stderr, "audit: %v\n", err)
+	}
+	mustLoad(reconciler.start)
+	go refreshIndexes()
+
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
+	// Plugins from custom indexes are addressed as "index/plugin"; clients
+	// send the slash escaped (%2F) so it stays within one :name segment.
+	r.UseRawPath = true
 
-	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Origin, Authorization, Content-Type, X-Rancher-Token")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
-	})
+	r.Use(activeCORSPolicy.middleware())
 
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "healthy"})
 	})
 
-	r.GET("/api/info", func(c *gin.Context) {
+	r.GET("/api/info", requireSession(), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
 		hostname, _ := os.Hostname()
 		c.JSON(200, gin.H{
 			"baseImage":   "alpine:latest",
 			"goVersion":  runtime.Version(),
 			"hostname":   hostname,
-			"krewRoot":   krewRoot(),
+			"krewRoot":   ws.KrewRoot,
+			"home":       ws.Home,
 		})
 	})
 
-	// ── Rancher clusters (for the UI dropdown) ──
+	// ── Current user and workstation permissions ──
 
-	tokenFromRequest := func(c *gin.Context) string {
-		if h := c.GetHeader("Authorization"); strings.HasPrefix(h, "Bearer ") {
-			return strings.TrimPrefix(h, "Bearer ")
-		}
-		return c.GetHeader("X-Rancher-Token")
-	}
+	r.GET("/api/me", requireSession(), func(c *gin.Context) {
+		user := c.MustGet("user").(*rancherUser)
+		c.JSON(200, gin.H{
+			"user":        user,
+			"permissions": activeRBACPolicy.permissionsFor(user),
+		})
+	})
 
-	r.GET("/api/clusters", func(c *gin.Context) {
+	// ── Rancher clusters (for the UI dropdown) ──
+
+	r.GET("/api/clusters", requireSession(), func(c *gin.Context) {
 		token := tokenFromRequest(c)
 		clusters, err := fetchClustersWithToken(token)
 		if err != nil {
@@ -456,7 +496,11 @@ func main() {
 
 	// ── Kubeconfig: sync from Rancher, get current context ──
 
-	r.POST("/api/kubeconfig/sync", func(c *gin.Context) {
+	r.POST("/api/kubeconfig/sync", requirePermission(permKubeconfigDownload), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
 		token := tokenFromRequest(c)
 		clusters, err := fetchClustersWithToken(token)
 		if err != nil {
@@ -481,20 +525,19 @@ func main() {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		kubeDir := filepath.Dir(kubeConfigPath())
-		if err := os.MkdirAll(kubeDir, 0700); err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		if err := os.WriteFile(kubeConfigPath(), merged, 0600); err != nil {
+		if err := ws.writeFile(ws.kubeConfigPath(), merged, 0600); err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 		c.JSON(200, gin.H{"message": "kubeconfig synced", "clusters": len(clusters)})
 	})
 
-	r.GET("/api/kubeconfig", func(c *gin.Context) {
-		data, err := os.ReadFile(kubeConfigPath())
+	r.GET("/api/kubeconfig", requirePermission(permKubeconfigDownload), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		data, err := ws.readFile(ws.kubeConfigPath())
 		if err != nil {
 			if os.IsNotExist(err) {
 				c.JSON(404, gin.H{"error": "kubeconfig not found; sync from Rancher first"})
@@ -507,8 +550,12 @@ func main() {
 		c.Data(200, "application/x-yaml", data)
 	})
 
-	r.GET("/api/context", func(c *gin.Context) {
-		out, err := runKubectlConfig("current-context")
+	r.GET("/api/context", requireSession(), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		out, err := runKubectlConfig(c.Request.Context(), ws, "current-context")
 		ctx := strings.TrimSpace(out)
 		if err != nil || ctx == "" {
 			c.JSON(200, gin.H{"context": ""})
@@ -519,97 +566,737 @@ func main() {
 
 	// ── Global plugin management (not per-cluster) ──
 
-	r.GET("/api/plugins", func(c *gin.Context) {
-		installedOutput, _ := runKrew("list")
-		installed := parseInstalledPlugins(installedOutput)
+	// The catalog, filtered, searched and paged as parsePluginQuery reads it.
+	// output=true adds the raw `kubectl krew search` output.
+	r.GET("/api/plugins", requirePermission(permCatalogView), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		query, err := parsePluginQuery(c.Request.URL.Query())
+		if err != nil {
+			c.JSON(400, PluginsResponse{Error: err.Error()})
+			return
+		}
+		installed, err := installedWithUpdates(ws)
+		if err != nil {
+			c.JSON(500, PluginsResponse{Error: err.Error()})
+			return
+		}
 
-		searchOutput, err := runKrew("search")
+		entries, err := catalogFor(ws).list()
 		if err != nil {
-			c.JSON(500, PluginsResponse{Error: err.Error(), TerminalOutput: searchOutput})
+			c.JSON(500, PluginsResponse{Error: err.Error()})
 			return
 		}
 
-		plugins := parseAvailablePlugins(searchOutput)
-		for i := range plugins {
-			if ver, ok := installed[plugins[i].Name]; ok {
+		plugins := make([]Plugin, len(entries))
+		for i, e := range entries {
+			plugins[i] = e.plugin()
+			if receipt, ok := installed[plugins[i].Name]; ok {
 				plugins[i].Installed = true
-				plugins[i].Version = ver
+				plugins[i].Version = receipt.Version
+				plugins[i].Upgradable = receipt.Upgradable
+				plugins[i].Receipt = receipt
+				plugins[i].PolicyViolation = receipt.PolicyViolation
+			}
+		}
+
+		page, total, facets := query.apply(plugins)
+		resp := PluginsResponse{Plugins: page, Total: total, Page: query.Page, PageSize: query.PageSize, Facets: facets}
+		for _, p := range sortedInstalled(installed) {
+			if p.PolicyViolation != "" {
+				resp.PolicyViolations = append(resp.PolicyViolations, p)
+			}
+		}
+		if c.Query("output") == "true" {
+			resp.TerminalOutput, _ = runKrewContext(c.Request.Context(), ws, "search")
+		}
+		fresh, age := indexFresh(ws)
+		if at := indexUpdatedAt(ws); !at.IsZero() {
+			resp.IndexUpdatedAt = &at
+			resp.IndexAgeSeconds = int64(age.Seconds())
+		}
+		resp.IndexStale = !fresh
+		c.JSON(200, resp)
+	})
+
+	r.POST("/api/plugins/:name/install", requirePermission(permPluginsManage), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		name, ok := indexedPluginName(c, ws)
+		if !ok || !allowedByPolicy(c, ws, name) {
+			return
+		}
+
+		// Air-gapped: install the index manifest with a cached archive.
+		if c.Query("offline") == "true" {
+			entry, _, err := catalogFor(ws).get(name)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			archive, err := cachedArchive(entry)
+			if err != nil {
+				archiveError(c, err, 404)
+				return
 			}
+			startJob(c, ws, "install", name, offlineInstallJob(ws, name, entry.Index, entry.File, archive, func() {}))
+			return
 		}
 
-		c.JSON(200, PluginsResponse{
-			Plugins:        plugins,
-			TerminalOutput: installedOutput + "\n" + searchOutput,
+		startJob(c, ws, "install", name, func(ctx context.Context, j *job) (interface{}, error) {
+			updateIndexIfStale(ctx, ws, j.emit)
+			if err := checkPolicy(ws, name); err != nil {
+				return nil, err
+			}
+			return nil, installPlugin(ctx, ws, name, j.emit)
 		})
 	})
 
-	r.POST("/api/plugins/:name/install", func(c *gin.Context) {
-		name := c.Param("name")
+	// Air-gapped install from an uploaded manifest and archive (multipart
+	// fields "manifest" and "archive").
+	r.POST("/api/plugins/upload", requirePermission(permPluginsUpload), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(envInt("PLUGIN_UPLOAD_MAX_MB", 256))<<20)
+		readFile := func(field string) ([]byte, string, error) {
+			fh, err := c.FormFile(field)
+			if err != nil {
+				return nil, "", fmt.Errorf("%s file is required", field)
+			}
+			f, err := fh.Open()
+			if err != nil {
+				return nil, "", err
+			}
+			defer f.Close()
+			data, err := io.ReadAll(f)
+			return data, fh.Filename, err
+		}
+		manifest, _, err := readFile("manifest")
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		archive, archiveName, err := readFile("archive")
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		m, err := parseUploadedManifest(manifest)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		name := m.Metadata.Name
+		if blocked, reason := activePluginPolicy.blocked(detachedIndex+"/"+name, m.Spec.Version); blocked {
+			c.JSON(403, gin.H{
+				"error":   fmt.Sprintf("plugin %s/%s %s is blocked by policy: %s", detachedIndex, name, m.Spec.Version, reason),
+				"reason":  reason,
+				"blocked": true,
+			})
+			return
+		}
+		manifestPath, archivePath, remove, err := stageUpload(ws, manifest, archiveName, archive)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		// Checked here as well as by krew, to answer with a clear error
+		// before a job is queued.
+		if err := verifyArchive(m, archivePath); err != nil {
+			remove()
+			archiveError(c, err, 400)
+			return
+		}
+
+		startJob(c, ws, "install", name, offlineInstallJob(ws, name, "", manifestPath, archivePath, remove))
+	})
+
+	r.DELETE("/api/plugins/:name", requirePermission(permPluginsManage), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		name, ok := installedPluginName(c, ws)
+		if !ok {
+			return
+		}
+
+		// krew uninstall and upgrade take the bare name, not index/plugin.
+		startJob(c, ws, "uninstall", name, krewJob(ws, false, "uninstall", baseName(name)))
+	})
+
+	r.POST("/api/plugins/:name/upgrade", requirePermission(permPluginsManage), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		name, ok := installedPluginName(c, ws)
+		if !ok || !allowedByPolicy(c, ws, name) {
+			return
+		}
+
+		startJob(c, ws, "upgrade", name, func(ctx context.Context, j *job) (interface{}, error) {
+			updateIndexIfStale(ctx, ws, j.emit)
+			if err := checkPolicy(ws, name); err != nil {
+				return nil, err
+			}
+			return nil, runKrewStream(ctx, ws, j.emit, "upgrade", baseName(name))
+		})
+	})
 
-		updateOut, _ := runKrew("update")
-		installOut, err := runKrew("install", name)
-		output := updateOut + "\n" + installOut
+	r.POST("/api/plugins/update", requirePermission(permPluginsManage), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		output, err := runKrewContext(c.Request.Context(), ws, "update")
+		// The krew output is only sent when asked for with output=true.
+		if c.Query("output") != "true" {
+			output = ""
+		}
 		if err != nil {
-			c.JSON(500, PluginsResponse{Error: err.Error(), TerminalOutput: output})
+			c.JSON(errorStatus(err), PluginsResponse{Error: err.Error(), TerminalOutput: output})
 			return
 		}
 		c.JSON(200, PluginsResponse{TerminalOutput: output})
 	})
 
-	r.DELETE("/api/plugins/:name", func(c *gin.Context) {
+	r.GET("/api/plugins/installed", requirePermission(permCatalogView), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		installed, err := installedPlugins(ws)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		details := sortedInstalled(installed)
+		names := make([]string, len(details))
+		for i, p := range details {
+			names[i] = p.Name
+		}
+		c.JSON(200, gin.H{"plugins": names, "details": details})
+	})
+
+	r.GET("/api/plugins/outdated", requirePermission(permCatalogView), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		outdated, err := outdatedPlugins(ws)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"plugins": outdated})
+	})
+
+	r.POST("/api/plugins/upgrade-all", requirePermission(permPluginsManage), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		// A dry run only reads receipts and the index, so it answers directly.
+		if c.Query("dryRun") == "true" {
+			results, err := upgradeAll(c.Request.Context(), ws, true, discardLines)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(200, gin.H{"dryRun": true, "results": results})
+			return
+		}
+
+		startJob(c, ws, "upgrade-all", "", func(ctx context.Context, j *job) (interface{}, error) {
+			updateIndexIfStale(ctx, ws, j.emit)
+			results, err := upgradeAll(ctx, ws, false, j.emit)
+			if err != nil {
+				return nil, err
+			}
+			failed := 0
+			for _, res := range results {
+				if res.Status == "failed" {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return results, fmt.Errorf("%d of %d upgrades failed", failed, len(results))
+			}
+			return results, nil
+		})
+	})
+
+	// Audit rehashes the plugin store against the hashes recorded at install
+	// time. scope=shared audits the backend's krew root, which is on every
+	// workspace's PATH.
+	r.GET("/api/plugins/audit", requirePermission(permCatalogView), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		if c.Query("scope") == "shared" {
+			ws = sharedWorkspace()
+		}
+		report, err := auditPlugins(ws)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, report)
+	})
+
+	// Accept the current store as the new baseline, e.g. for plugins
+	// installed from a shell, which are not recorded.
+	r.POST("/api/plugins/audit/baseline", requirePermission(permPluginsManage), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		if c.Query("scope") == "shared" {
+			ws = sharedWorkspace()
+		}
+		release, err := krewQueueFor(ws.KrewRoot).acquire(c.Request.Context())
+		if err != nil {
+			c.JSON(503, gin.H{"error": err.Error()})
+			return
+		}
+		defer release()
+		if err := recordPluginHashes(ws, true); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		report, err := auditPlugins(ws)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, report)
+	})
+
+	// Export in the format asked for: yaml (default), json, or text, the
+	// `kubectl krew list` format that `kubectl krew install` reads.
+	r.GET("/api/plugins/export", requirePermission(permCatalogView), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		set, err := exportPluginSet(ws)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		switch format := c.DefaultQuery("format", "yaml"); format {
+		case "yaml":
+			data, err := yaml.Marshal(set)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			c.Header("Content-Disposition", "attachment; filename=plugins.yaml")
+			c.Data(200, "application/x-yaml", data)
+		case "json":
+			c.Header("Content-Disposition", "attachment; filename=plugins.json")
+			c.JSON(200, set)
+		case "text":
+			c.Header("Content-Disposition", "attachment; filename=plugins.txt")
+			c.Data(200, "text/plain; charset=utf-8", []byte(set.krewList()))
+		default:
+			c.JSON(400, gin.H{"error": fmt.Sprintf("unknown format %q (yaml, json or text)", format)})
+		}
+	})
+
+	// Import accepts any export format as the request body.
+	r.POST("/api/plugins/import", requirePermission(permPluginsManage), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		set, err := parsePluginSet(body)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		startJob(c, ws, "import", "", func(ctx context.Context, j *job) (interface{}, error) {
+			updateIndexIfStale(ctx, ws, j.emit)
+			results, err := importPluginSet(ctx, ws, set, j.emit)
+			if err != nil {
+				return results, err
+			}
+			failed := 0
+			for _, res := range results {
+				if res.Status != "installed" && res.Status != "skipped" {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return results, fmt.Errorf("%d of %d plugins could not be imported", failed, len(results))
+			}
+			return results, nil
+		})
+	})
+
+	r.GET("/api/plugins/:name", requirePermission(permCatalogView), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
 		name := c.Param("name")
+		if err := validPluginName(name); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		name = canonicalPluginName(name)
 
-		output, err := runKrew("uninstall", name)
+		detail, found, err := pluginDetails(c.Request.Context(), ws, name)
 		if err != nil {
-			c.JSON(500, PluginsResponse{Error: err.Error(), TerminalOutput: output})
+			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(200, PluginsResponse{TerminalOutput: output})
+		if !found {
+			c.JSON(404, gin.H{"error": fmt.Sprintf("plugin %q not found in any index", name)})
+			return
+		}
+		c.JSON(200, detail)
+	})
+
+	// ── Archive cache ──
+
+	r.GET("/api/cache", requirePermission(permCatalogView), func(c *gin.Context) {
+		entries, total, err := listArchiveCache()
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"enabled": archiveCacheEnabled(), "archives": entries, "totalBytes": total})
+	})
+
+	// Prefetch the archive of a plugin for this host, e.g. before going
+	// offline.
+	r.POST("/api/cache/:name", requirePermission(permPluginsManage), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		name, ok := indexedPluginName(c, ws)
+		if !ok {
+			return
+		}
+		entry, _, err := catalogFor(ws).get(name)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		p, err := cacheablePlatform(entry)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		startJob(c, ws, "cache", name, func(ctx context.Context, j *job) (interface{}, error) {
+			path, err := fetchArchive(ctx, p, j.emit)
+			if err != nil {
+				return nil, err
+			}
+			return gin.H{"sha256": p.SHA256, "path": path}, nil
+		})
+	})
+
+	r.GET("/mirror/sha256/:sha256", serveMirror)
+
+	// ── Krew indexes ──
+
+	r.GET("/api/indexes", requirePermission(permCatalogView), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		indexes, err := listIndexes(ws)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"indexes": indexes})
+	})
+
+	r.POST("/api/indexes", requirePermission(permPluginsManage), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+			URL  string `json:"url"`
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if !indexNamePattern.MatchString(req.Name) {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("invalid index name %q", req.Name)})
+			return
+		}
+		if err := validIndexURL(req.URL); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := os.Stat(filepath.Join(ws.KrewRoot, "index", req.Name)); err == nil {
+			c.JSON(409, gin.H{"error": fmt.Sprintf("index %q already exists", req.Name)})
+			return
+		}
+
+		startJob(c, ws, "index-add", "", krewJob(ws, false, "index", "add", req.Name, req.URL))
 	})
 
-	r.POST("/api/plugins/:name/upgrade", func(c *gin.Context) {
+	r.DELETE("/api/indexes/:name", requirePermission(permPluginsManage), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
 		name := c.Param("name")
+		if !indexNamePattern.MatchString(name) {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("invalid index name %q", name)})
+			return
+		}
+		if _, err := os.Stat(filepath.Join(ws.KrewRoot, "index", name)); err != nil {
+			c.JSON(404, gin.H{"error": fmt.Sprintf("index %q not found", name)})
+			return
+		}
+		// krew refuses to remove an index that plugins were installed from.
+		installed, err := installedPlugins(ws)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		var inUse []string
+		for _, p := range sortedInstalled(installed) {
+			if p.Index == name {
+				inUse = append(inUse, p.Name)
+			}
+		}
+		if len(inUse) > 0 {
+			c.JSON(409, gin.H{"error": fmt.Sprintf("index %q is used by installed plugins: %s", name, strings.Join(inUse, ", ")), "plugins": inUse})
+			return
+		}
+
+		startJob(c, ws, "index-remove", "", krewJob(ws, false, "index", "remove", name))
+	})
+
+	// ── Local index for internal plugins ──
 
-		updateOut, _ := runKrew("update")
-		upgradeOut, err := runKrew("upgrade", name)
-		output := updateOut + "\n" + upgradeOut
+	r.GET("/api/local-index", requirePermission(permCatalogView), func(c *gin.Context) {
+		plugins, err := listLocalIndex()
 		if err != nil {
-			c.JSON(500, PluginsResponse{Error: err.Error(), TerminalOutput: output})
+			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(200, PluginsResponse{TerminalOutput: output})
+		c.JSON(200, gin.H{
+			"name":      localIndexName(),
+			"url":       "file://" + localIndexRepo(),
+			"published": localIndexPublished(),
+			"plugins":   plugins,
+		})
 	})
 
-	r.POST("/api/plugins/update", func(c *gin.Context) {
-		output, err := runKrew("update")
+	// Multipart upload: a "manifest" file and one "archive" file per
+	// platform archive, matched to the platforms by sha256.
+	r.POST("/api/local-index/plugins", requirePermission(permPluginsPublish), func(c *gin.Context) {
+		user := c.MustGet("user").(*rancherUser)
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(envInt("LOCAL_INDEX_MAX_UPLOAD_MB", 256))<<20)
+		form, err := c.MultipartForm()
 		if err != nil {
-			c.JSON(500, PluginsResponse{Error: err.Error(), TerminalOutput: output})
+			c.JSON(400, gin.H{"error": "invalid upload: " + err.Error()})
 			return
 		}
-		c.JSON(200, PluginsResponse{TerminalOutput: output})
+		readPart := func(field string) ([][]byte, []string, error) {
+			var data [][]byte
+			var names []string
+			for _, fh := range form.File[field] {
+				f, err := fh.Open()
+				if err != nil {
+					return nil, nil, err
+				}
+				b, err := io.ReadAll(f)
+				f.Close()
+				if err != nil {
+					return nil, nil, err
+				}
+				data = append(data, b)
+				names = append(names, fh.Filename)
+			}
+			return data, names, nil
+		}
+		manifests, _, err := readPart("manifest")
+		if err != nil || len(manifests) != 1 {
+			c.JSON(400, gin.H{"error": "exactly one manifest file is required"})
+			return
+		}
+		data, names, err := readPart("archive")
+		if err != nil || len(data) == 0 {
+			c.JSON(400, gin.H{"error": "at least one archive file is required"})
+			return
+		}
+		archives := make([]uploadedArchive, len(data))
+		for i := range data {
+			archives[i] = uploadedArchive{Name: names[i], Data: data[i]}
+		}
+		m, err := publishLocalPlugin(manifests[0], archives, user.Username)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		// Workspaces pick the index up on their next request; the
+		// uploader's does right away.
+		workspaceFor(user)
+		c.JSON(201, gin.H{"plugin": localIndexName() + "/" + m.Metadata.Name, "manifest": m})
 	})
 
-	r.GET("/api/plugins/installed", func(c *gin.Context) {
-		output, err := runKrew("list")
+	r.DELETE("/api/local-index/plugins/:name", requirePermission(permPluginsPublish), func(c *gin.Context) {
+		user := c.MustGet("user").(*rancherUser)
+		name := c.Param("name")
+		if !pluginNamePattern.MatchString(name) {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("invalid plugin name %q", name)})
+			return
+		}
+		found, err := removeLocalPlugin(name, user.Username)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		installed := parseInstalledPlugins(output)
-		names := make([]string, 0, len(installed))
-		for n := range installed {
-			names = append(names, n)
+		if !found {
+			c.JSON(404, gin.H{"error": fmt.Sprintf("plugin %q is not in the local index", name)})
+			return
+		}
+		c.JSON(200, gin.H{"removed": name})
+	})
+
+	r.GET("/local-index/archives/:sha256/:file", serveLocalArchive)
+
+	// ── Shared plugin profile ──
+
+	r.GET("/api/profile", requirePermission(permCatalogView), func(c *gin.Context) {
+		c.JSON(200, reconciler.snapshot())
+	})
+
+	r.POST("/api/profile/reconcile", requirePermission(permPluginsManage), func(c *gin.Context) {
+		if !reconciler.requestReconcile() {
+			c.JSON(409, gin.H{"error": "a reconcile is already running or queued", "status": reconciler.snapshot()})
+			return
+		}
+		c.JSON(202, reconciler.snapshot())
+	})
+
+	// ── Asynchronous plugin operations ──
+
+	r.GET("/api/jobs", requirePermission(permCatalogView), func(c *gin.Context) {
+		user := c.MustGet("user").(*rancherUser)
+		c.JSON(200, gin.H{"jobs": jobs.list(user.ID)})
+	})
+
+	r.GET("/api/jobs/:id", requirePermission(permCatalogView), func(c *gin.Context) {
+		user := c.MustGet("user").(*rancherUser)
+		j, ok := jobs.get(user.ID, c.Param("id"))
+		if !ok {
+			c.JSON(404, gin.H{"error": "job not found"})
+			return
 		}
-		c.JSON(200, gin.H{"plugins": names})
+		c.JSON(200, j)
+	})
+
+	// Server-sent events: one "line" event per transcript line (replayed from
+	// the start), then an "end" event with the final job.
+	r.GET("/api/jobs/:id/stream", requirePermission(permCatalogView), func(c *gin.Context) {
+		user := c.MustGet("user").(*rancherUser)
+		j, ok := jobs.lookup(user.ID, c.Param("id"))
+		if !ok {
+			c.JSON(404, gin.H{"error": "job not found"})
+			return
+		}
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		cursor := 0
+		for {
+			lines, finished, changed := j.linesSince(cursor)
+			for _, l := range lines {
+				c.SSEvent("line", l)
+			}
+			cursor += len(lines)
+			if finished {
+				end := j.snapshot()
+				end.Output, end.Transcript = "", nil
+				c.SSEvent("end", end)
+				c.Writer.Flush()
+				return
+			}
+			c.Writer.Flush()
+			select {
+			case <-changed:
+			case <-c.Request.Context().Done():
+				return
+			}
+		}
+	})
+
+	r.POST("/api/jobs/:id/cancel", requirePermission(permPluginsManage), func(c *gin.Context) {
+		user := c.MustGet("user").(*rancherUser)
+		j, ok := jobs.cancelJob(user.ID, c.Param("id"))
+		if !ok {
+			c.JSON(404, gin.H{"error": "job not found"})
+			return
+		}
+		c.JSON(200, j)
 	})
 
 	// ── WebSocket PTY shell (real bash session in the container) ──
 
 	wsUpgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
+		CheckOrigin:  checkWSOrigin,
+		Subprotocols: []string{wsShellProtocol},
 	}
 
+	// Short-lived single-use ticket for clients that cannot send the token
+	// as a subprotocol or cookie: GET /api/ws/shell?ticket=...
+	r.POST("/api/ws/ticket", requirePermission(permShellOpen), func(c *gin.Context) {
+		user := c.MustGet("user").(*rancherUser)
+		ticket, err := issueWSTicket(user, c.GetString("token"))
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(200, gin.H{"ticket": ticket, "expiresIn": int(wsTicketTTL.Seconds())})
+	})
+
 	r.GET("/api/ws/shell", func(c *gin.Context) {
+		if !checkWSOrigin(c.Request) {
+			c.JSON(403, gin.H{"error": "origin not allowed"})
+			return
+		}
+		user, _, err := authenticateWS(c)
+		if err != nil {
+			c.JSON(401, gin.H{"error": "shell requires a Rancher session: " + err.Error()})
+			return
+		}
+		if !activeRBACPolicy.allows(user, permShellOpen) {
+			permissionDenied(c, user, permShellOpen)
+			return
+		}
+		ws, err := workspaceFor(user)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
 		conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
 			return
@@ -617,19 +1304,20 @@ func main() {
 		defer conn.Close()
 
 		// Fetch welcome in background (kk list, version, update, info) — blocks up to 12s
-		welcome := fetchWelcome()
+		welcome := fetchWelcome(c.Request.Context(), ws)
 
 		shell := "/bin/bash"
 		if _, err := os.Stat(shell); os.IsNotExist(err) {
 			shell = "/bin/sh"
 		}
 
-		cmd := exec.Command(shell, "-i")
-		cmd.Env = append(os.Environ(),
-			"TERM=xterm-256color",
-			"KREW_ROOT="+krewRoot(),
-			fmt.Sprintf("PATH=%s:%s", filepath.Join(krewRoot(), "bin"), os.Getenv("PATH")),
-		)
+		// Resource limits are applied by the shell itself before it turns
+		// interactive, so they hold for everything started in the session.
+		cmd := ws.command(shell, "-c", shellLimits()+"exec "+shell+" -i")
+		cmd.Env = append(cmd.Env, "TERM=xterm-256color")
+		// pty.Start puts the shell in a new session, which already makes it
+		// a process group leader; Setpgid would make setsid fail.
+		cmd.SysProcAttr.Setpgid = false
 
 		ptmx, err := pty.Start(cmd)
 		if err != nil {
@@ -637,6 +1325,13 @@ func main() {
 			return
 		}
 		defer ptmx.Close()
+		// When the socket goes away, take down what the shell started and
+		// reap it instead of leaving it to the next container restart. Jobs
+		// run in process groups of their own, so the whole session goes.
+		defer func() {
+			killSession(cmd.Process.Pid)
+			cmd.Wait()
+		}()
 
 		// Send custom welcome (fetched in background)
 		conn.WriteMessage(websocket.BinaryMessage, []byte(welcome))
@@ -683,33 +1378,45 @@ func main() {
 
 	// ── Filesystem browser (safe, restricted paths) ──
 
-	allowedDirs := map[string]bool{
-		"/root": true, "/app": true, "/tmp": true,
-	}
-
-	r.GET("/api/fs", func(c *gin.Context) {
+	r.GET("/api/fs", requirePermission(permFSBrowse), func(c *gin.Context) {
+		ws, ok := currentWorkspace(c)
+		if !ok {
+			return
+		}
+		// Users only see their own home, never another workspace.
+		allowedDirs := []string{ws.Home, "/app", "/tmp"}
+		allowed := func(path string) bool {
+			for _, dir := range allowedDirs {
+				if path == dir || strings.HasPrefix(path, dir+"/") {
+					return true
+				}
+			}
+			return false
+		}
 		rawPath := c.Query("path")
 		if rawPath == "" {
-			rawPath = "/root"
+			rawPath = ws.Home
 		}
 		clean := filepath.Clean(rawPath)
 		if !filepath.IsAbs(clean) {
-			clean = filepath.Join("/root", clean)
+			clean = filepath.Join(ws.Home, clean)
 		}
-		if !allowedDirs[clean] {
-			allowed := false
-			for dir := range allowedDirs {
-				if strings.HasPrefix(clean, dir+"/") || clean == dir {
-					allowed = true
-					break
-				}
-			}
-			if !allowed {
-				c.JSON(403, gin.H{"error": "path not allowed"})
-				return
-			}
+		if !allowed(clean) {
+			c.JSON(403, gin.H{"error": "path not allowed"})
+			return
+		}
+		// The directory is read as root, so a symlink in the home or in /tmp
+		// must not lead out of the allowed directories.
+		resolved, err := filepath.EvalSymlinks(clean)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if !allowed(resolved) {
+			c.JSON(403, gin.H{"error": "path not allowed"})
+			return
 		}
-		entries, err := os.ReadDir(clean)
+		entries, err := os.ReadDir(resolved)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
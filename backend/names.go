@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Plugin and index names as krew and krew-index accept them. Neither may
+// start with "-", so a name can never be read as a flag.
+var (
+	pluginNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	indexNamePattern  = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+)
+
+// validPluginName checks name against the krew grammar: "plugin" or
+// "index/plugin".
+func validPluginName(name string) error {
+	index, plugin := defaultIndex, name
+	if i := strings.IndexByte(name, '/'); i >= 0 {
+		index, plugin = name[:i], name[i+1:]
+		if !indexNamePattern.MatchString(index) {
+			return fmt.Errorf("invalid index name %q", index)
+		}
+	}
+	if !pluginNamePattern.MatchString(plugin) {
+		return fmt.Errorf("invalid plugin name %q", plugin)
+	}
+	return nil
+}
+
+// canonicalPluginName is how name is keyed in the catalog and receipts:
+// "default/foo" and "foo" are the same plugin.
+func canonicalPluginName(name string) string {
+	return strings.TrimPrefix(name, defaultIndex+"/")
+}
+
+// indexedPluginName reads the :name parameter and checks that it names a
+// plugin of the index. Otherwise it answers 400 or 404 and returns false.
+func indexedPluginName(c *gin.Context, ws *workspace) (string, bool) {
+	name := c.Param("name")
+	if err := validPluginName(name); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return "", false
+	}
+	name = canonicalPluginName(name)
+	_, found, err := catalogFor(ws).get(name)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if !found {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("plugin %q not found in any index", name)})
+		return "", false
+	}
+	return name, true
+}
+
+// installedPluginName reads the :name parameter and checks that it names an
+// installed plugin. Otherwise it answers 400 or 404 and returns false.
+func installedPluginName(c *gin.Context, ws *workspace) (string, bool) {
+	name := c.Param("name")
+	if err := validPluginName(name); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return "", false
+	}
+	name = canonicalPluginName(name)
+	installed, err := installedPlugins(ws)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if _, ok := installed[name]; !ok {
+		c.JSON(404, gin.H{"error": fmt.Sprintf("plugin %q is not installed", name)})
+		return "", false
+	}
+	return name, true
+}
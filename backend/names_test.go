@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestValidPluginName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"stern", true},
+		{"view-secret", true},
+		{"get_all", true},
+		{"ns2", true},
+		{"default/stern", true},
+		{"my-Index_1/foo", true},
+		{"", false},
+		{"-stern", false},
+		{"--help", false},
+		{"Stern", false},
+		{"stern.sh", false},
+		{"../stern", false},
+		{"a/b/c", false},
+		{"/stern", false},
+		{"index/", false},
+		{"-index/foo", false},
+		{"index/-foo", false},
+		{"stern foo", false},
+	}
+	for _, tt := range tests {
+		if err := validPluginName(tt.name); (err == nil) != tt.valid {
+			t.Errorf("validPluginName(%q) = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
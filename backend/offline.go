@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// Air-gapped installs hand krew a manifest and an archive, so it never
+// downloads anything: `krew install --manifest=... --archive=...`.
+
+// archiveCacheDir holds plugin archives for offline installs: the archive
+// cache (sha256/<sum>), plus archives copied in by hand as
+// <plugin>/<version>/<file> or flat as <file>, named like the last path
+// element of the platform URI.
+func archiveCacheDir() string {
+	if d := os.Getenv("ARCHIVE_CACHE_DIR"); d != "" {
+		return d
+	}
+	return filepath.Join(workspacesDir(), ".archives")
+}
+
+// checksumError is a sha256 mismatch between an archive and its manifest.
+type checksumError struct {
+	Archive string
+	Got     string
+	Want    string
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: archive has sha256 %s, but the manifest expects %s for %s/%s",
+		e.Archive, e.Got, e.Want, runtime.GOOS, runtime.GOARCH)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyArchive checks archive against the platform of m that krew picks
+// on this host.
+func verifyArchive(m *pluginManifest, archive string) error {
+	p := hostPlatform(m.Spec)
+	if p == nil {
+		return fmt.Errorf("plugin %s has no platform for %s/%s", m.Metadata.Name, runtime.GOOS, runtime.GOARCH)
+	}
+	sum, err := fileSHA256(archive)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(sum, p.SHA256) {
+		return &checksumError{Archive: filepath.Base(archive), Got: sum, Want: strings.ToLower(p.SHA256)}
+	}
+	return nil
+}
+
+// parseUploadedManifest checks an uploaded manifest enough to run krew on
+// it and to apply the plugin policy.
+func parseUploadedManifest(data []byte) (*pluginManifest, error) {
+	var m pluginManifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("parse manifest: %w", err)
+	}
+	if m.APIVersion != manifestAPIVersion || m.Kind != "Plugin" {
+		return nil, fmt.Errorf("manifest must be apiVersion %s, kind Plugin", manifestAPIVersion)
+	}
+	if err := validPluginName(m.Metadata.Name); err != nil || strings.Contains(m.Metadata.Name, "/") {
+		return nil, fmt.Errorf("invalid plugin name %q", m.Metadata.Name)
+	}
+	return &m, nil
+}
+
+// cachedArchive finds and verifies the archive of the index plugin entry in
+// the archive cache.
+func cachedArchive(entry *indexPlugin) (string, error) {
+	m := &entry.Manifest
+	p := hostPlatform(m.Spec)
+	if p == nil {
+		return "", fmt.Errorf("plugin %s has no platform for %s/%s", m.Metadata.Name, runtime.GOOS, runtime.GOARCH)
+	}
+	// The manifest comes from the workspace's index checkout, which its
+	// user can edit, so only plain names may become paths in the cache.
+	sum := strings.ToLower(p.SHA256)
+	if !sha256Pattern.MatchString(sum) {
+		return "", fmt.Errorf("plugin %s has an invalid sha256 %q", m.Metadata.Name, p.SHA256)
+	}
+	candidates := []string{cachedArchivePath(sum)}
+	if file := filepath.Base(p.URI); validArchiveFile(file) {
+		if _, _, ok := parseVersion(m.Spec.Version); ok && pluginNamePattern.MatchString(m.Metadata.Name) && validArchiveFile(m.Spec.Version) {
+			candidates = append(candidates, filepath.Join(archiveCacheDir(), m.Metadata.Name, m.Spec.Version, file))
+		}
+		candidates = append(candidates, filepath.Join(archiveCacheDir(), file))
+	}
+	for _, c := range candidates {
+		if _, err := os.Stat(c); err != nil {
+			continue
+		}
+		if err := verifyArchive(m, c); err != nil {
+			return "", err
+		}
+		return c, nil
+	}
+	return "", fmt.Errorf("no cached archive for %s %s (looked for %s)", m.Metadata.Name, m.Spec.Version, strings.Join(candidates, ", "))
+}
+
+// stageUpload writes an uploaded manifest and archive to a directory the
+// workspace user can read. remove deletes it again.
+func stageUpload(ws *workspace, manifest []byte, archiveName string, archive []byte) (manifestPath, archivePath string, remove func(), err error) {
+	sweepUploads(ws)
+	dir, err := os.MkdirTemp(ws.Dir, "upload-")
+	if err != nil {
+		return "", "", nil, err
+	}
+	remove = func() { os.RemoveAll(dir) }
+	manifestPath = filepath.Join(dir, "manifest.yaml")
+	base := archiveName
+	if !validArchiveFile(base) || base == filepath.Base(manifestPath) {
+		base = "archive"
+	}
+	archivePath = filepath.Join(dir, base)
+	// The directory is in the workspace, so write as its user.
+	if err = ws.chown(dir); err == nil {
+		err = ws.writeFile(manifestPath, manifest, 0600)
+	}
+	if err == nil {
+		err = ws.writeFile(archivePath, archive, 0600)
+	}
+	if err != nil {
+		remove()
+		return "", "", nil, err
+	}
+	return manifestPath, archivePath, remove, nil
+}
+
+// sweepUploads removes staged uploads left over by jobs that never ran,
+// e.g. because they were canceled while queued.
+func sweepUploads(ws *workspace) {
+	dirs, _ := filepath.Glob(filepath.Join(ws.Dir, "upload-*"))
+	for _, d := range dirs {
+		if fi, err := os.Stat(d); err == nil && time.Since(fi.ModTime()) > 24*time.Hour {
+			os.RemoveAll(d)
+		}
+	}
+}
+
+// detachedIndex is the index krew records for a plugin installed from a
+// manifest of no index. Uploads are checked against the plugin policy under
+// it, so their self-declared name cannot borrow the rules of an index plugin.
+const detachedIndex = "detached"
+
+// offlineInstallJob installs name from a manifest and archive on disk,
+// calling done afterwards. index is the index the manifest comes from, or
+// "" for an uploaded one, which krew records as detachedIndex.
+func offlineInstallJob(ws *workspace, name, index, manifestPath, archivePath string, done func()) jobFunc {
+	return func(ctx context.Context, j *job) (interface{}, error) {
+		defer done()
+		return nil, installArchive(ctx, ws, name, index, manifestPath, archivePath, j.emit)
+	}
+}
+
+// archiveError answers a failed archive check: 422 with both sums for a
+// checksum mismatch, status for anything else.
+func archiveError(c *gin.Context, err error, status int) {
+	var sumErr *checksumError
+	if errors.As(err, &sumErr) {
+		c.JSON(422, gin.H{"error": err.Error(), "archive": sumErr.Archive, "sha256": sumErr.Got, "expected": sumErr.Want})
+		return
+	}
+	c.JSON(status, gin.H{"error": err.Error()})
+}
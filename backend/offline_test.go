@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestCachedArchiveStaysInCache(t *testing.T) {
+	root := t.TempDir()
+	cache := filepath.Join(root, "cache")
+	t.Setenv("ARCHIVE_CACHE_DIR", cache)
+	archive := []byte("archive")
+	sum := sha256.Sum256(archive)
+	good := hex.EncodeToString(sum[:])
+	for path, data := range map[string][]byte{
+		filepath.Join(cache, "foo", "v1.0.0", "foo.tar.gz"): archive,
+		filepath.Join(cache, "bar.tar.gz"):                  archive,
+		filepath.Join(root, "secret"):                       []byte("secret"),
+		filepath.Join(root, "x", "v1.0.0", "secret"):        []byte("secret"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry := func(name, version, uri, sha string) *indexPlugin {
+		var m pluginManifest
+		m.Metadata.Name = name
+		m.Spec.Version = version
+		m.Spec.Platforms = []pluginPlatform{{
+			Selector: &labelSelector{MatchLabels: map[string]string{"os": runtime.GOOS, "arch": runtime.GOARCH}},
+			URI:      uri,
+			SHA256:   sha,
+		}}
+		return &indexPlugin{Index: defaultIndex, Manifest: m}
+	}
+	tests := []struct {
+		desc  string
+		entry *indexPlugin
+		want  string // "" for no archive found
+	}{
+		{"by plugin and version", entry("foo", "v1.0.0", "https://example.com/foo.tar.gz", good), filepath.Join(cache, "foo", "v1.0.0", "foo.tar.gz")},
+		{"by file name", entry("bar", "v1.0.0", "https://example.com/dl/bar.tar.gz", good), filepath.Join(cache, "bar.tar.gz")},
+		{"version escapes the cache", entry("foo", "../..", "https://example.com/secret", good), ""},
+		{"version with a path", entry("foo", "v1.0.0/../../..", "https://example.com/secret", good), ""},
+		{"name escapes the cache", entry("../x", "v1.0.0", "https://example.com/secret", good), ""},
+		{"file name escapes the cache", entry("foo", "v1.0.0", "..", good), ""},
+		{"invalid sha256", entry("foo", "v1.0.0", "https://example.com/foo.tar.gz", "../../secret"), ""},
+	}
+	for _, tt := range tests {
+		got, err := cachedArchive(tt.entry)
+		var sumErr *checksumError
+		switch {
+		case errors.As(err, &sumErr):
+			t.Errorf("%s: hashed %s outside the cache", tt.desc, sumErr.Archive)
+		case tt.want == "" && err == nil:
+			t.Errorf("%s: cachedArchive = %q, want an error", tt.desc, got)
+		case tt.want != "" && (err != nil || got != tt.want):
+			t.Errorf("%s: cachedArchive = %q, %v; want %q", tt.desc, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"strings"
+)
+
+// upgradeResult is the outcome of upgrading one plugin in upgrade-all.
+type upgradeResult struct {
+	Name        string `json:"name"`
+	FromVersion string `json:"fromVersion"`
+	ToVersion   string `json:"toVersion"`
+	Status      string `json:"status"` // "upgraded", "failed", "blocked" or "would-upgrade" (dry run)
+	Error       string `json:"error,omitempty"`
+	Output      string `json:"output,omitempty"`
+}
+
+// installedWithUpdates reads the receipts of ws and fills in LatestVersion
+// and Upgradable from the index, and PolicyViolation from the policy.
+func installedWithUpdates(ws *workspace) (map[string]*installedPlugin, error) {
+	installed, err := installedPlugins(ws)
+	if err != nil {
+		return nil, err
+	}
+	cat := catalogFor(ws)
+	for name, p := range installed {
+		if blocked, reason := activePluginPolicy.blocked(name, p.Version); blocked {
+			p.PolicyViolation = reason
+		}
+		entry, ok, err := cat.get(name)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		p.LatestVersion = entry.Manifest.Spec.Version
+		p.Upgradable = compareVersions(p.Version, p.LatestVersion) < 0
+	}
+	return installed, nil
+}
+
+// outdatedPlugins returns the installed plugins with a newer version in
+// their index, sorted by name.
+func outdatedPlugins(ws *workspace) ([]*installedPlugin, error) {
+	installed, err := installedWithUpdates(ws)
+	if err != nil {
+		return nil, err
+	}
+	outdated := []*installedPlugin{}
+	for _, p := range sortedInstalled(installed) {
+		if p.Upgradable {
+			outdated = append(outdated, p)
+		}
+	}
+	return outdated, nil
+}
+
+// upgradeAll upgrades every outdated plugin one by one, passing krew output
+// to emit. With dryRun it only reports what would be upgraded.
+func upgradeAll(ctx context.Context, ws *workspace, dryRun bool, emit lineFunc) ([]upgradeResult, error) {
+	outdated, err := outdatedPlugins(ws)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]upgradeResult, 0, len(outdated))
+	for _, p := range outdated {
+		res := upgradeResult{Name: p.Name, FromVersion: p.Version, ToVersion: p.LatestVersion}
+		if blocked, reason := activePluginPolicy.blocked(p.Name, p.LatestVersion); blocked {
+			res.Status = "blocked"
+			res.Error = reason
+			results = append(results, res)
+			continue
+		}
+		if dryRun {
+			res.Status = "would-upgrade"
+			results = append(results, res)
+			continue
+		}
+		var output strings.Builder
+		err := runKrewStream(ctx, ws, func(stream, text string) {
+			output.WriteString(text + "\n")
+			emit(stream, text)
+		}, "upgrade", baseName(p.Name))
+		res.Output = output.String()
+		if err != nil {
+			res.Status = "failed"
+			res.Error = err.Error()
+		} else {
+			res.Status = "upgraded"
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// pluginRule matches plugins by glob pattern on the qualified name ("foo",
+// "myindex/foo", "myindex/*"; the default index can also be written as
+// "default/foo"), optionally only for versions meeting a constraint such
+// as ">=v0.5.0, <v1.0.0". As in paths, "*" does not match "/": "*" covers
+// the default index, "*/*" every other one.
+type pluginRule struct {
+	Plugins  []string `yaml:"plugins" json:"plugins"`
+	Versions string   `yaml:"versions" json:"versions,omitempty"`
+	Reason   string   `yaml:"reason" json:"reason,omitempty"`
+}
+
+// pluginPolicy decides which plugins may be installed or upgraded. A plugin
+// matching a deny rule is blocked. If there are allow rules, a plugin must
+// also match one of them.
+type pluginPolicy struct {
+	Allow []pluginRule `yaml:"allow" json:"allow"`
+	Deny  []pluginRule `yaml:"deny" json:"deny"`
+}
+
+var activePluginPolicy pluginPolicy
+
+// loadPluginPolicy reads the allow and deny rules of PLUGIN_POLICY_FILE and
+// checks their patterns and version constraints up front. Without the file
+// every plugin is allowed.
+func loadPluginPolicy() error {
+	file := os.Getenv("PLUGIN_POLICY_FILE")
+	if file == "" {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read plugin policy: %w", err)
+	}
+	var p pluginPolicy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("parse plugin policy %s: %w", file, err)
+	}
+	for _, rule := range append(append([]pluginRule(nil), p.Allow...), p.Deny...) {
+		for _, pattern := range rule.Plugins {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("plugin policy %s: bad pattern %q", file, pattern)
+			}
+		}
+		if _, err := parseConstraints(rule.Versions); err != nil {
+			return fmt.Errorf("plugin policy %s: %w", file, err)
+		}
+	}
+	activePluginPolicy = p
+	return nil
+}
+
+// versionConstraint is one comparison such as ">=v1.2.0".
+type versionConstraint struct {
+	op      string
+	version string
+}
+
+// parseConstraints parses a comma-separated list of constraints, all of
+// which must hold. Supported operators are =, !=, <, <=, > and >=.
+func parseConstraints(s string) ([]versionConstraint, error) {
+	var list []versionConstraint
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		op := "="
+		for _, o := range []string{">=", "<=", "!=", "=", "<", ">"} {
+			if strings.HasPrefix(part, o) {
+				op = o
+				break
+			}
+		}
+		v := strings.TrimSpace(strings.TrimPrefix(part, op))
+		if _, _, ok := parseVersion(v); !ok {
+			return nil, fmt.Errorf("invalid version constraint %q", part)
+		}
+		list = append(list, versionConstraint{op: op, version: v})
+	}
+	return list, nil
+}
+
+func (vc versionConstraint) matches(version string) bool {
+	cmp := compareVersions(version, vc.version)
+	switch vc.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// matchesName reports whether a pattern of the rule matches plugin name.
+func (r pluginRule) matchesName(name string) bool {
+	name = canonicalPluginName(name)
+	names := []string{name}
+	if !strings.Contains(name, "/") {
+		names = append(names, defaultIndex+"/"+name)
+	}
+	for _, pattern := range r.Plugins {
+		for _, n := range names {
+			if ok, _ := path.Match(pattern, n); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchesVersion reports whether version meets all constraints of the rule.
+func (r pluginRule) matchesVersion(version string) bool {
+	constraints, _ := parseConstraints(r.Versions)
+	for _, vc := range constraints {
+		if !vc.matches(version) {
+			return false
+		}
+	}
+	return true
+}
+
+// blocked reports whether the policy forbids installing version of plugin
+// name, and why.
+func (p pluginPolicy) blocked(name, version string) (bool, string) {
+	for _, rule := range p.Deny {
+		if rule.matchesName(name) && rule.matchesVersion(version) {
+			return true, ruleReason(rule, "denied by plugin policy")
+		}
+	}
+	if len(p.Allow) == 0 {
+		return false, ""
+	}
+	reason := "not on the plugin allowlist"
+	for _, rule := range p.Allow {
+		if !rule.matchesName(name) {
+			continue
+		}
+		if rule.matchesVersion(version) {
+			return false, ""
+		}
+		reason = ruleReason(rule, fmt.Sprintf("version %s is not allowed (%s)", version, rule.Versions))
+	}
+	return true, reason
+}
+
+func ruleReason(rule pluginRule, fallback string) string {
+	if rule.Reason != "" {
+		return rule.Reason
+	}
+	return fallback
+}
+
+// allowedByPolicy checks the index version of plugin name against the
+// plugin policy. A blocked plugin is answered with 403 and the reason.
+func allowedByPolicy(c *gin.Context, ws *workspace, name string) bool {
+	entry, found, err := catalogFor(ws).get(name)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return false
+	}
+	if !found {
+		return true // nothing to install; krew reports that itself
+	}
+	version := entry.Manifest.Spec.Version
+	if blocked, reason := activePluginPolicy.blocked(name, version); blocked {
+		c.JSON(403, gin.H{
+			"error":   fmt.Sprintf("plugin %s %s is blocked by policy: %s", name, version, reason),
+			"reason":  reason,
+			"blocked": true,
+		})
+		return false
+	}
+	return true
+}
+
+// checkPolicy is allowedByPolicy for jobs: it checks the version the index
+// has now, after the job refreshed it, so a stale index cannot let a newer,
+// blocked version through.
+func checkPolicy(ws *workspace, name string) error {
+	entry, found, err := catalogFor(ws).get(name)
+	if err != nil || !found {
+		return err
+	}
+	version := entry.Manifest.Spec.Version
+	if blocked, reason := activePluginPolicy.blocked(name, version); blocked {
+		return fmt.Errorf("plugin %s %s is blocked by policy: %s", name, version, reason)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConstraints(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []versionConstraint
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"v1.0.0", []versionConstraint{{"=", "v1.0.0"}}, false},
+		{">=v1.0.0, <v2.0.0", []versionConstraint{{">=", "v1.0.0"}, {"<", "v2.0.0"}}, false},
+		{"!= v1.2.3", []versionConstraint{{"!=", "v1.2.3"}}, false},
+		{"<=1.0,>0.5", []versionConstraint{{"<=", "1.0"}, {">", "0.5"}}, false},
+		{"=v1.0.0-rc.1", []versionConstraint{{"=", "v1.0.0-rc.1"}}, false},
+		{">=", nil, true},
+		{"~v1.0.0", nil, true},
+		{">=v1.x", nil, true},
+		{"v1.0.0, latest", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseConstraints(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseConstraints(%q) error = %v, want error %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseConstraints(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPluginPolicyBlocked(t *testing.T) {
+	policy := pluginPolicy{
+		Allow: []pluginRule{
+			{Plugins: []string{"*"}},
+			{Plugins: []string{"internal/*"}},
+			{Plugins: []string{"tools/stern"}, Versions: ">=v1.25.0"},
+		},
+		Deny: []pluginRule{
+			{Plugins: []string{"sniff"}, Reason: "Captures pod traffic"},
+			{Plugins: []string{"stern"}, Versions: "<v1.25.0"},
+			{Plugins: []string{"detached/*"}, Reason: "No uploads"},
+		},
+	}
+	tests := []struct {
+		name, version string
+		blocked       bool
+		reason        string
+	}{
+		{"stern", "v1.25.0", false, ""},
+		{"default/stern", "v1.30.0", false, ""},
+		{"stern", "v1.24.9", true, "denied by plugin policy"},
+		{"default/stern", "v1.0.0", true, "denied by plugin policy"},
+		{"sniff", "v1.0.0", true, "Captures pod traffic"},
+		{"default/sniff", "v1.0.0", true, "Captures pod traffic"},
+		{"internal/deploy", "v0.1.0", false, ""},
+		{"other/deploy", "v0.1.0", true, "not on the plugin allowlist"},
+		{"tools/stern", "v1.25.0", false, ""},
+		{"tools/stern", "v1.24.0", true, "version v1.24.0 is not allowed (>=v1.25.0)"},
+		{"detached/stern", "v1.30.0", true, "No uploads"},
+	}
+	for _, tt := range tests {
+		blocked, reason := policy.blocked(tt.name, tt.version)
+		if blocked != tt.blocked || reason != tt.reason {
+			t.Errorf("blocked(%q, %q) = %v, %q; want %v, %q", tt.name, tt.version, blocked, reason, tt.blocked, tt.reason)
+		}
+	}
+}
+
+func TestEmptyPluginPolicyAllowsEverything(t *testing.T) {
+	for _, name := range []string{"stern", "index/foo", "detached/foo"} {
+		if blocked, reason := (pluginPolicy{}).blocked(name, "v1.0.0"); blocked {
+			t.Errorf("blocked(%q) = true (%s), want false", name, reason)
+		}
+	}
+}
+
+func TestPluginPolicyDenyOnly(t *testing.T) {
+	policy := pluginPolicy{Deny: []pluginRule{{Plugins: []string{"*/*"}}}}
+	tests := []struct {
+		name    string
+		blocked bool
+	}{
+		{"stern", true}, // "*/*" also matches default/stern
+		{"index/foo", true},
+		{"detached/foo", true},
+	}
+	for _, tt := range tests {
+		if blocked, _ := policy.blocked(tt.name, "v1.0.0"); blocked != tt.blocked {
+			t.Errorf("blocked(%q) = %v, want %v", tt.name, blocked, tt.blocked)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pluginSet is a portable list of plugins, as exported from one workstation
+// and imported on another.
+type pluginSet struct {
+	Plugins []pluginSetEntry `yaml:"plugins" json:"plugins"`
+}
+
+type pluginSetEntry struct {
+	Name    string `yaml:"name" json:"name"`
+	Index   string `yaml:"index,omitempty" json:"index,omitempty"`
+	Version string `yaml:"version,omitempty" json:"version,omitempty"`
+}
+
+// qualifiedName is the krew name of the entry, "name" or "index/name".
+func (e pluginSetEntry) qualifiedName() string {
+	if e.Index == "" || e.Index == defaultIndex || strings.Contains(e.Name, "/") {
+		return canonicalPluginName(e.Name)
+	}
+	return e.Index + "/" + e.Name
+}
+
+// importResult is the outcome of importing one plugin set entry.
+type importResult struct {
+	Name             string `json:"name"`
+	Version          string `json:"version,omitempty"`
+	InstalledVersion string `json:"installedVersion,omitempty"`
+	Status           string `json:"status"` // "installed", "skipped", "blocked", "not-found" or "failed"
+	Message          string `json:"message,omitempty"`
+	Output           string `json:"output,omitempty"`
+}
+
+// exportPluginSet lists the installed plugins of ws.
+func exportPluginSet(ws *workspace) (pluginSet, error) {
+	installed, err := installedPlugins(ws)
+	if err != nil {
+		return pluginSet{}, err
+	}
+	set := pluginSet{Plugins: []pluginSetEntry{}}
+	for _, p := range sortedInstalled(installed) {
+		set.Plugins = append(set.Plugins, pluginSetEntry{Name: baseName(p.Name), Index: p.Index, Version: p.Version})
+	}
+	return set, nil
+}
+
+// krewList renders the set like `kubectl krew list`, one name per line,
+// which `kubectl krew install` reads from stdin.
+func (s pluginSet) krewList() string {
+	var b strings.Builder
+	for _, e := range s.Plugins {
+		b.WriteString(e.qualifiedName())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// parsePluginSet accepts the JSON and YAML export formats as well as a
+// plain `kubectl krew list` output.
+func parsePluginSet(data []byte) (pluginSet, error) {
+	var set pluginSet
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case len(trimmed) == 0:
+		return set, fmt.Errorf("empty plugin list")
+	case isPluginSetDocument(trimmed):
+		// JSON is YAML, so one decoder covers both export formats.
+		if err := yaml.Unmarshal(trimmed, &set); err != nil {
+			return set, fmt.Errorf("parse plugin list: %w", err)
+		}
+	default:
+		sc := bufio.NewScanner(bytes.NewReader(trimmed))
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			set.Plugins = append(set.Plugins, pluginSetEntry{Name: line})
+		}
+	}
+	var invalid []string
+	for _, e := range set.Plugins {
+		if err := validPluginName(e.qualifiedName()); err != nil {
+			invalid = append(invalid, err.Error())
+		}
+	}
+	if len(invalid) > 0 {
+		return set, fmt.Errorf("%s", strings.Join(invalid, "; "))
+	}
+	return set, nil
+}
+
+func isPluginSetDocument(data []byte) bool {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return false
+	}
+	_, ok := doc["plugins"]
+	return ok
+}
+
+// importPluginSet installs the entries of set that are missing in ws, one
+// by one, passing krew output to emit. Krew always installs the version in
+// the index; a different exported version is noted in the result.
+func importPluginSet(ctx context.Context, ws *workspace, set pluginSet, emit lineFunc) ([]importResult, error) {
+	installed, err := installedPlugins(ws)
+	if err != nil {
+		return nil, err
+	}
+	cat := catalogFor(ws)
+	results := make([]importResult, 0, len(set.Plugins))
+	for _, e := range set.Plugins {
+		name := e.qualifiedName()
+		res := importResult{Name: name, Version: e.Version}
+		if p, ok := installed[name]; ok {
+			res.Status, res.InstalledVersion = "skipped", p.Version
+			res.Message = "already installed"
+			results = append(results, res)
+			continue
+		}
+		entry, found, err := cat.get(name)
+		if err != nil {
+			return results, err
+		}
+		if !found {
+			res.Status, res.Message = "not-found", fmt.Sprintf("plugin %q not found in any index", name)
+			results = append(results, res)
+			continue
+		}
+		version := entry.Manifest.Spec.Version
+		if blocked, reason := activePluginPolicy.blocked(name, version); blocked {
+			res.Status, res.Message = "blocked", reason
+			results = append(results, res)
+			continue
+		}
+		var output strings.Builder
+		err = installPlugin(ctx, ws, name, func(stream, text string) {
+			output.WriteString(text + "\n")
+			emit(stream, text)
+		})
+		res.Output = output.String()
+		if err != nil {
+			res.Status, res.Message = "failed", err.Error()
+		} else {
+			res.Status, res.InstalledVersion = "installed", version
+			installed[name] = &installedPlugin{Name: name, Version: version}
+			if e.Version != "" && compareVersions(e.Version, version) != 0 {
+				res.Message = fmt.Sprintf("index has %s, the list asked for %s", version, e.Version)
+			}
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
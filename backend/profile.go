@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pluginProfile is the desired state of the shared krew root, whose plugins
+// are on the PATH of every workspace.
+type pluginProfile struct {
+	// Plugins that must be installed, as "plugin" or "index/plugin", in
+	// the order they are installed.
+	Plugins []string `yaml:"plugins" json:"plugins"`
+	// Prune uninstalls plugins that are not in the profile.
+	Prune bool `yaml:"prune" json:"prune"`
+	// Interval between reconcile runs (Go duration). 0 reconciles only at
+	// startup and when asked to.
+	Interval string `yaml:"interval" json:"interval,omitempty"`
+}
+
+// defaultPluginProfile is used when PLUGIN_PROFILE_FILE is not set.
+var defaultPluginProfile = pluginProfile{
+	Plugins:  []string{"k9s", "ssh-jump", "stern", "lineage", "get-all", "crust-gather"},
+	Interval: "15m",
+}
+
+// defaultProfileInterval applies when the profile sets no interval.
+const defaultProfileInterval = 15 * time.Minute
+
+// Plugin states reported by the reconciler.
+const (
+	profilePending    = "pending"
+	profileInstalling = "installing"
+	profileInstalled  = "installed"
+	profileBlocked    = "blocked"
+	profileFailed     = "failed"
+	profileRemoved    = "removed"
+)
+
+// profilePluginStatus is the reconcile state of one plugin.
+type profilePluginStatus struct {
+	Name        string     `json:"name"`
+	State       string     `json:"state"`
+	Version     string     `json:"version,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Output      string     `json:"output,omitempty"`
+	LastAttempt *time.Time `json:"lastAttempt,omitempty"`
+}
+
+// profileStatus is what GET /api/profile reports.
+type profileStatus struct {
+	Source       string                `json:"source"`
+	Profile      pluginProfile         `json:"profile"`
+	Running      bool                  `json:"running"`
+	LastStarted  *time.Time            `json:"lastStarted,omitempty"`
+	LastFinished *time.Time            `json:"lastFinished,omitempty"`
+	NextRun      *time.Time            `json:"nextRun,omitempty"`
+	Error        string                `json:"error,omitempty"`
+	Plugins      []profilePluginStatus `json:"plugins"`
+	Removed      []profilePluginStatus `json:"removed,omitempty"`
+	// Extra lists installed plugins outside the profile that are kept
+	// because pruning is off.
+	Extra []string `json:"extra,omitempty"`
+}
+
+// profileReconciler keeps the shared krew root in line with the profile.
+type profileReconciler struct {
+	mu      sync.Mutex
+	ws      *workspace
+	status  profileStatus
+	trigger chan struct{}
+}
+
+var reconciler = &profileReconciler{trigger: make(chan struct{}, 1)}
+
+// sharedWorkspace is the backend's own krew root, where krew itself and
+// the profile plugins live.
+func sharedWorkspace() *workspace {
+	home, _ := os.UserHomeDir()
+	return &workspace{UserID: "", Dir: home, Home: home, KrewRoot: krewRoot()}
+}
+
+// loadPluginProfile returns the profile in PLUGIN_PROFILE_FILE, with
+// canonical plugin names, or defaultPluginProfile when it is not set. Every
+// reconcile pass calls it, so edits apply without a restart.
+func loadPluginProfile() (pluginProfile, string, error) {
+	file := os.Getenv("PLUGIN_PROFILE_FILE")
+	if file == "" {
+		return defaultPluginProfile, "built-in", nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return pluginProfile{}, file, fmt.Errorf("read plugin profile: %w", err)
+	}
+	var p pluginProfile
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return pluginProfile{}, file, fmt.Errorf("parse plugin profile %s: %w", file, err)
+	}
+	for i, name := range p.Plugins {
+		if err := validPluginName(name); err != nil {
+			return pluginProfile{}, file, fmt.Errorf("plugin profile %s: %w", file, err)
+		}
+		p.Plugins[i] = canonicalPluginName(name)
+	}
+	if _, err := p.interval(); err != nil {
+		return pluginProfile{}, file, fmt.Errorf("plugin profile %s: %w", file, err)
+	}
+	return p, file, nil
+}
+
+func (p pluginProfile) interval() (time.Duration, error) {
+	if p.Interval == "" {
+		return defaultProfileInterval, nil
+	}
+	d, err := time.ParseDuration(p.Interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval %q", p.Interval)
+	}
+	return d, nil
+}
+
+// start checks the profile and runs the reconcile loop in the background.
+func (r *profileReconciler) start() error {
+	profile, source, err := loadPluginProfile()
+	if err != nil {
+		return err
+	}
+	r.ws = sharedWorkspace()
+	r.status.Source = source
+	r.status.Profile = profile
+	for _, name := range profile.Plugins {
+		r.status.Plugins = append(r.status.Plugins, profilePluginStatus{Name: name, State: profilePending})
+	}
+	go r.loop()
+	return nil
+}
+
+func (r *profileReconciler) loop() {
+	for {
+		interval := r.reconcile(context.Background())
+
+		var wait <-chan time.Time
+		if interval > 0 {
+			next := time.Now().Add(interval)
+			r.mu.Lock()
+			r.status.NextRun = &next
+			r.mu.Unlock()
+			wait = time.After(interval)
+		}
+		select {
+		case <-r.trigger:
+		case <-wait:
+		}
+	}
+}
+
+// requestReconcile asks the loop for a run now. It reports false when a
+// run is already running or requested.
+func (r *profileReconciler) requestReconcile() bool {
+	r.mu.Lock()
+	running := r.status.Running
+	r.mu.Unlock()
+	if running {
+		return false
+	}
+	select {
+	case r.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// snapshot returns a copy of the status that is safe to serialize.
+func (r *profileReconciler) snapshot() profileStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.status
+	s.Plugins = append([]profilePluginStatus(nil), s.Plugins...)
+	s.Removed = append([]profilePluginStatus(nil), s.Removed...)
+	s.Extra = append([]string(nil), s.Extra...)
+	return s
+}
+
+// setPlugin records the state of the profile plugin at index i.
+func (r *profileReconciler) setPlugin(i int, st profilePluginStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.Plugins[i] = st
+}
+
+// reconcile makes one pass: reload the profile, refresh the index, install
+// what is missing and, with prune, uninstall what is not wanted. It returns
+// the interval until the next pass.
+func (r *profileReconciler) reconcile(ctx context.Context) time.Duration {
+	now := time.Now()
+	r.mu.Lock()
+	r.status.Running = true
+	r.status.LastStarted = &now
+	r.status.NextRun = nil
+	r.status.Error = ""
+	profile := r.status.Profile
+	r.mu.Unlock()
+
+	var errs []string
+	defer func() {
+		end := time.Now()
+		r.mu.Lock()
+		r.status.Running = false
+		r.status.LastFinished = &end
+		r.status.Error = strings.Join(errs, "; ")
+		r.mu.Unlock()
+	}()
+
+	// Pick up ConfigMap changes; a broken file keeps the last good profile.
+	if p, source, err := loadPluginProfile(); err != nil {
+		errs = append(errs, err.Error())
+	} else {
+		profile = p
+		r.mu.Lock()
+		r.status.Source = source
+		r.status.Profile = p
+		// Keep the last outcome of each plugin until it is retried.
+		previous := make(map[string]profilePluginStatus, len(r.status.Plugins))
+		for _, st := range r.status.Plugins {
+			previous[st.Name] = st
+		}
+		r.status.Plugins = make([]profilePluginStatus, len(p.Plugins))
+		for i, name := range p.Plugins {
+			st, ok := previous[name]
+			if !ok {
+				st = profilePluginStatus{Name: name, State: profilePending}
+			}
+			r.status.Plugins[i] = st
+		}
+		r.mu.Unlock()
+	}
+	interval, _ := profile.interval()
+
+	// A failed update is not fatal: the existing index may still do.
+	if fresh, _ := indexFresh(r.ws); !fresh {
+		if err := runKrewStream(ctx, r.ws, discardLines, "update"); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	installed, err := installedPlugins(r.ws)
+	if err != nil {
+		errs = append(errs, err.Error())
+		return interval
+	}
+	cat := catalogFor(r.ws)
+	for i, name := range profile.Plugins {
+		st := profilePluginStatus{Name: name}
+		if p, ok := installed[name]; ok {
+			st.State, st.Version = profileInstalled, p.Version
+			r.setPlugin(i, st)
+			continue
+		}
+		attempt := time.Now()
+		st.LastAttempt = &attempt
+		entry, found, err := cat.get(name)
+		switch {
+		case err != nil:
+			st.State, st.Error = profileFailed, err.Error()
+		case !found:
+			st.State, st.Error = profileFailed, fmt.Sprintf("plugin %q not found in any index", name)
+		default:
+			version := entry.Manifest.Spec.Version
+			if blocked, reason := activePluginPolicy.blocked(name, version); blocked {
+				st.State, st.Error = profileBlocked, reason
+				break
+			}
+			st.State = profileInstalling
+			r.setPlugin(i, st)
+			var out strings.Builder
+			if err := installPlugin(ctx, r.ws, name, collectLines(&out)); err != nil {
+				st.State, st.Error, st.Output = profileFailed, err.Error(), out.String()
+			} else {
+				st.State, st.Version = profileInstalled, version
+			}
+		}
+		if st.State == profileFailed {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, st.Error))
+		}
+		r.setPlugin(i, st)
+	}
+
+	var extra []string
+	for name := range installed {
+		// krew manages itself as a plugin of the shared root.
+		if name != "krew" && !containsString(profile.Plugins, name) {
+			extra = append(extra, name)
+		}
+	}
+	sort.Strings(extra)
+	var removed []profilePluginStatus
+	if profile.Prune {
+		for _, name := range extra {
+			attempt := time.Now()
+			st := profilePluginStatus{Name: name, State: profileRemoved, LastAttempt: &attempt}
+			var out strings.Builder
+			if err := runKrewStream(ctx, r.ws, collectLines(&out), "uninstall", baseName(name)); err != nil {
+				st.State, st.Error, st.Output = profileFailed, err.Error(), out.String()
+				errs = append(errs, fmt.Sprintf("%s: %s", name, st.Error))
+			}
+			removed = append(removed, st)
+		}
+		extra = nil
+	}
+	r.mu.Lock()
+	r.status.Removed = removed
+	r.status.Extra = extra
+	r.mu.Unlock()
+	return interval
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const maxPageSize = 500
+
+// pluginQuery is the search, filters, sort order and page of a catalog
+// listing, from the query parameters of /api/plugins.
+type pluginQuery struct {
+	Terms      []string
+	Installed  *bool
+	Upgradable *bool
+	Blocked    *bool
+	Index      string
+	Category   string
+	Sort       string // "relevance", "name", "index" or "installed"
+	Desc       bool
+	Page       int // 1-based; 0 returns everything
+	PageSize   int
+}
+
+var pluginSorts = map[string]bool{"relevance": true, "name": true, "index": true, "installed": true}
+
+// parsePluginQuery reads q, installed, upgradable, blocked, index,
+// category, sort ("-name" sorts descending), page and pageSize. Without q
+// the default order is by name, with q by relevance.
+func parsePluginQuery(v url.Values) (*pluginQuery, error) {
+	q := &pluginQuery{Terms: strings.Fields(strings.ToLower(v.Get("q"))), Index: v.Get("index"), Category: v.Get("category"), Sort: "name"}
+	if len(q.Terms) > 0 {
+		q.Sort = "relevance"
+	}
+	for key, dst := range map[string]**bool{"installed": &q.Installed, "upgradable": &q.Upgradable, "blocked": &q.Blocked} {
+		s := v.Get(key)
+		if s == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false", key)
+		}
+		*dst = &b
+	}
+	if s := v.Get("sort"); s != "" {
+		q.Desc = strings.HasPrefix(s, "-")
+		q.Sort = strings.TrimPrefix(s, "-")
+		if !pluginSorts[q.Sort] {
+			return nil, fmt.Errorf("unknown sort %q (relevance, name, index or installed)", q.Sort)
+		}
+	}
+	if s := v.Get("page"); s != "" {
+		page, err := strconv.Atoi(s)
+		if err != nil || page < 1 {
+			return nil, fmt.Errorf("page must be a positive number")
+		}
+		q.Page = page
+		q.PageSize = 50
+	}
+	if s := v.Get("pageSize"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 1 || size > maxPageSize {
+			return nil, fmt.Errorf("pageSize must be between 1 and %d", maxPageSize)
+		}
+		q.PageSize = size
+		if q.Page == 0 {
+			q.Page = 1
+		}
+	}
+	return q, nil
+}
+
+// score ranks how well p matches the search terms; 0 means no match. Every
+// term has to match somewhere, and matches in the name count most.
+func (q *pluginQuery) score(p *Plugin) int {
+	name := strings.ToLower(baseName(p.Name))
+	full := strings.ToLower(p.Name)
+	desc := strings.ToLower(p.Description)
+	long := strings.ToLower(p.LongDescription)
+	total := 0
+	for _, t := range q.Terms {
+		s := 0
+		switch {
+		case name == t || full == t:
+			s = 100
+		case strings.HasPrefix(name, t):
+			s = 40
+		case strings.Contains(full, t):
+			s = 20
+		}
+		if strings.Contains(desc, t) {
+			s += 10
+		}
+		if strings.Contains(long, t) {
+			s += 2
+		}
+		if s == 0 {
+			return 0
+		}
+		total += s
+	}
+	return total
+}
+
+// matches applies the filters to p, except the one named skip, so facet
+// counts of a filter do not depend on its own value.
+func (q *pluginQuery) matches(p *Plugin, skip string) bool {
+	switch {
+	case q.Installed != nil && p.Installed != *q.Installed,
+		q.Upgradable != nil && p.Upgradable != *q.Upgradable,
+		q.Blocked != nil && p.Blocked != *q.Blocked,
+		skip != "index" && q.Index != "" && p.Index != q.Index,
+		skip != "category" && q.Category != "" && !containsString(p.Categories, q.Category):
+		return false
+	}
+	return true
+}
+
+// facetCount is how many plugins have one value of a facet.
+type facetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type pluginFacets struct {
+	Categories []facetCount `json:"categories"`
+	Indexes    []facetCount `json:"indexes"`
+}
+
+func sortedFacet(counts map[string]int) []facetCount {
+	facet := make([]facetCount, 0, len(counts))
+	for v, n := range counts {
+		facet = append(facet, facetCount{Value: v, Count: n})
+	}
+	sort.Slice(facet, func(i, j int) bool {
+		if facet[i].Count != facet[j].Count {
+			return facet[i].Count > facet[j].Count
+		}
+		return facet[i].Value < facet[j].Value
+	})
+	return facet
+}
+
+// apply filters and sorts plugins and cuts out the page. It returns the
+// page, the number of plugins that matched and the facets of the search.
+func (q *pluginQuery) apply(plugins []Plugin) ([]Plugin, int, *pluginFacets) {
+	list := make([]Plugin, 0, len(plugins))
+	scores := make(map[string]int)
+	categories := make(map[string]int)
+	indexes := make(map[string]int)
+	for i := range plugins {
+		p := &plugins[i]
+		if len(q.Terms) > 0 {
+			s := q.score(p)
+			if s == 0 {
+				continue
+			}
+			scores[p.Name] = s
+		}
+		if q.matches(p, "category") {
+			for _, c := range p.Categories {
+				categories[c]++
+			}
+		}
+		if q.matches(p, "index") {
+			indexes[p.Index]++
+		}
+		if q.matches(p, "") {
+			list = append(list, *p)
+		}
+	}
+	facets := &pluginFacets{Categories: sortedFacet(categories), Indexes: sortedFacet(indexes)}
+
+	less := func(a, b *Plugin) bool {
+		switch q.Sort {
+		case "relevance":
+			if scores[a.Name] != scores[b.Name] {
+				return scores[a.Name] > scores[b.Name]
+			}
+		case "index":
+			if a.Index != b.Index {
+				return a.Index < b.Index
+			}
+		case "installed":
+			if a.Installed != b.Installed {
+				return a.Installed
+			}
+		}
+		return a.Name < b.Name
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		if q.Desc {
+			return less(&list[j], &list[i])
+		}
+		return less(&list[i], &list[j])
+	})
+
+	total := len(list)
+	if q.Page == 0 {
+		return list, total, facets
+	}
+	start := (q.Page - 1) * q.PageSize
+	if start > total {
+		start = total
+	}
+	end := start + q.PageSize
+	if end > total {
+		end = total
+	}
+	return list[start:end], total, facets
+}
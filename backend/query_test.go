@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func testCatalog() []Plugin {
+	return []Plugin{
+		{Name: "stern", Description: "Multi pod and container log tailing", Index: "default", Categories: []string{"logs"}, Installed: true},
+		{Name: "tail", Description: "Stream logs from workloads", Index: "default", Categories: []string{"logs"}},
+		{Name: "sniff", Description: "Capture network traffic", Index: "default", Categories: []string{"networking", "debugging"}, Blocked: true},
+		{Name: "who-can", Description: "Shows who has RBAC permissions", Index: "default", Categories: []string{"rbac"}, Installed: true, Upgradable: true},
+		{Name: "internal/deploy", Description: "Deploy internal services", Index: "internal", Categories: []string{"workloads"}},
+		{Name: "internal/logs", Description: "Internal log shipping", Index: "internal", Categories: []string{"logs"}},
+	}
+}
+
+func names(plugins []Plugin) []string {
+	list := make([]string, len(plugins))
+	for i, p := range plugins {
+		list[i] = p.Name
+	}
+	return list
+}
+
+func TestPluginQueryApply(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+		total int
+	}{
+		{"", []string{"internal/deploy", "internal/logs", "sniff", "stern", "tail", "who-can"}, 6},
+		{"sort=-name", []string{"who-can", "tail", "stern", "sniff", "internal/logs", "internal/deploy"}, 6},
+		{"installed=true", []string{"stern", "who-can"}, 2},
+		{"installed=false&index=internal", []string{"internal/deploy", "internal/logs"}, 2},
+		{"upgradable=true", []string{"who-can"}, 1},
+		{"blocked=true", []string{"sniff"}, 1},
+		{"category=logs", []string{"internal/logs", "stern", "tail"}, 3},
+		{"category=logs&index=default", []string{"stern", "tail"}, 2},
+		// Name matches rank above description matches.
+		{"q=logs", []string{"internal/logs", "tail"}, 2},
+		{"q=tail", []string{"tail", "stern"}, 2},
+		{"q=log+tail", []string{"tail", "stern"}, 2},
+		{"q=log+traffic", []string{}, 0}, // every term has to match
+		{"q=nothing", []string{}, 0},
+		{"sort=installed", []string{"stern", "who-can", "internal/deploy", "internal/logs", "sniff", "tail"}, 6},
+		{"sort=index", []string{"sniff", "stern", "tail", "who-can", "internal/deploy", "internal/logs"}, 6},
+		{"page=1&pageSize=4", []string{"internal/deploy", "internal/logs", "sniff", "stern"}, 6},
+		{"page=2&pageSize=4", []string{"tail", "who-can"}, 6},
+		{"page=3&pageSize=4", []string{}, 6},
+		{"pageSize=2", []string{"internal/deploy", "internal/logs"}, 6},
+	}
+	for _, tt := range tests {
+		v, _ := url.ParseQuery(tt.query)
+		q, err := parsePluginQuery(v)
+		if err != nil {
+			t.Errorf("%q: %v", tt.query, err)
+			continue
+		}
+		page, total, _ := q.apply(testCatalog())
+		if got := names(page); !reflect.DeepEqual(got, tt.want) || total != tt.total {
+			t.Errorf("%q: got %v (total %d), want %v (total %d)", tt.query, got, total, tt.want, tt.total)
+		}
+	}
+}
+
+func TestPluginQueryFacets(t *testing.T) {
+	v, _ := url.ParseQuery("category=logs&index=internal")
+	q, err := parsePluginQuery(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, facets := q.apply(testCatalog())
+	// Each facet ignores its own filter but applies the others.
+	wantCategories := []facetCount{{"logs", 1}, {"workloads", 1}}
+	wantIndexes := []facetCount{{"default", 2}, {"internal", 1}}
+	if !reflect.DeepEqual(facets.Categories, wantCategories) {
+		t.Errorf("categories = %v, want %v", facets.Categories, wantCategories)
+	}
+	if !reflect.DeepEqual(facets.Indexes, wantIndexes) {
+		t.Errorf("indexes = %v, want %v", facets.Indexes, wantIndexes)
+	}
+}
+
+func TestParsePluginQueryErrors(t *testing.T) {
+	for _, query := range []string{
+		"installed=maybe",
+		"sort=size",
+		"page=0",
+		"page=-1",
+		"pageSize=0",
+		"pageSize=501",
+		"pageSize=x",
+	} {
+		v, _ := url.ParseQuery(query)
+		if _, err := parsePluginQuery(v); err == nil {
+			t.Errorf("parsePluginQuery(%q) succeeded, want an error", query)
+		}
+	}
+}
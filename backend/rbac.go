@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+)
+
+// Workstation permissions granted by the RBAC policy.
+const (
+	permCatalogView        = "catalog.view"
+	permPluginsManage      = "plugins.manage"
+	permKubeconfigDownload = "kubeconfig.download"
+	permShellOpen          = "shell.open"
+	permFSBrowse           = "fs.browse"
+	permPluginsPublish     = "plugins.publish"
+	permPluginsUpload      = "plugins.upload"
+)
+
+var allPermissions = []string{
+	permCatalogView, permPluginsManage, permKubeconfigDownload, permShellOpen, permFSBrowse,
+	permPluginsPublish, permPluginsUpload,
+}
+
+// rbacRule grants permissions to users matching any of its subjects.
+type rbacRule struct {
+	GlobalRoles []string `yaml:"globalRoles" json:"globalRoles,omitempty"`
+	Groups      []string `yaml:"groups" json:"groups,omitempty"`
+	Users       []string `yaml:"users" json:"users,omitempty"`
+	Permissions []string `yaml:"permissions" json:"permissions"`
+}
+
+// rbacPolicy maps Rancher global roles, group principals and user IDs to
+// workstation permissions. Default applies to every authenticated user.
+type rbacPolicy struct {
+	Default []string   `yaml:"default" json:"default"`
+	Rules   []rbacRule `yaml:"rules" json:"rules"`
+}
+
+// defaultRBACPolicy is used when RBAC_POLICY_FILE is not set: Rancher admins
+// get everything, everybody else can only browse the catalog.
+var defaultRBACPolicy = rbacPolicy{
+	Default: []string{permCatalogView},
+	Rules: []rbacRule{
+		{GlobalRoles: []string{"admin"}, Permissions: []string{"*"}},
+	},
+}
+
+var activeRBACPolicy = defaultRBACPolicy
+
+// loadRBACPolicy replaces defaultRBACPolicy with RBAC_POLICY_FILE when it
+// is set. Unknown permissions are refused, so a typo fails the start
+// instead of quietly granting nothing.
+func loadRBACPolicy() error {
+	path := os.Getenv("RBAC_POLICY_FILE")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read RBAC policy: %w", err)
+	}
+	var p rbacPolicy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return fmt.Errorf("parse RBAC policy %s: %w", path, err)
+	}
+	known := map[string]bool{"*": true}
+	for _, perm := range allPermissions {
+		known[perm] = true
+	}
+	check := func(perms []string) error {
+		for _, perm := range perms {
+			if !known[perm] {
+				return fmt.Errorf("RBAC policy %s: unknown permission %q", path, perm)
+			}
+		}
+		return nil
+	}
+	if err := check(p.Default); err != nil {
+		return err
+	}
+	for _, rule := range p.Rules {
+		if err := check(rule.Permissions); err != nil {
+			return err
+		}
+	}
+	activeRBACPolicy = p
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(list, values []string) bool {
+	for _, v := range values {
+		if containsString(list, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// permissionsFor returns the sorted set of permissions the policy grants user.
+func (p rbacPolicy) permissionsFor(user *rancherUser) []string {
+	granted := make(map[string]bool)
+	grant := func(perms []string) {
+		for _, perm := range perms {
+			if perm == "*" {
+				for _, a := range allPermissions {
+					granted[a] = true
+				}
+				continue
+			}
+			granted[perm] = true
+		}
+	}
+	grant(p.Default)
+	for _, rule := range p.Rules {
+		if containsString(rule.Users, user.ID) ||
+			matchesAny(rule.Groups, user.Groups) ||
+			matchesAny(rule.GlobalRoles, user.GlobalRoles) {
+			grant(rule.Permissions)
+		}
+	}
+	perms := make([]string, 0, len(granted))
+	for perm := range granted {
+		perms = append(perms, perm)
+	}
+	sort.Strings(perms)
+	return perms
+}
+
+func (p rbacPolicy) allows(user *rancherUser, perm string) bool {
+	return containsString(p.permissionsFor(user), perm)
+}
+
+// resolveUserRoles fills in the group principals and global roles of user.
+// Group principals come from the user's own token; global role bindings are
+// read with RANCHER_TOKEN when set, since standard users may not list them.
+func resolveUserRoles(user *rancherUser, token string) {
+	if body, err := rancherRequestWithToken("GET", "/v3/principals", token); err == nil {
+		var result struct {
+			Data []struct {
+				ID            string `json:"id"`
+				PrincipalType string `json:"principalType"`
+			} `json:"data"`
+		}
+		if json.Unmarshal(body, &result) == nil {
+			for _, p := range result.Data {
+				if p.PrincipalType == "group" {
+					user.Groups = append(user.Groups, p.ID)
+				}
+			}
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "rbac: principals for %s: %v\n", user.ID, err)
+	}
+
+	bindingsToken := rancherToken()
+	if bindingsToken == "" {
+		bindingsToken = token
+	}
+	path := "/v3/globalrolebindings?userId=" + url.QueryEscape(user.ID)
+	body, err := rancherRequestWithToken("GET", path, bindingsToken)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rbac: global roles for %s: %v\n", user.ID, err)
+		return
+	}
+	var result struct {
+		Data []struct {
+			GlobalRoleID string `json:"globalRoleId"`
+		} `json:"data"`
+	}
+	if json.Unmarshal(body, &result) == nil {
+		for _, b := range result.Data {
+			user.GlobalRoles = append(user.GlobalRoles, b.GlobalRoleID)
+		}
+	}
+}
+
+// requireSession rejects requests without a valid Rancher session and stores
+// the user and token on the context.
+func requireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := tokenFromRequest(c)
+		user, err := validateRancherToken(token)
+		if err != nil {
+			c.AbortWithStatusJSON(401, gin.H{"error": "Rancher session required: " + err.Error()})
+			return
+		}
+		c.Set("user", user)
+		c.Set("token", token)
+	}
+}
+
+// requirePermission is requireSession plus a policy check for perm.
+func requirePermission(perm string) gin.HandlerFunc {
+	session := requireSession()
+	return func(c *gin.Context) {
+		session(c)
+		if c.IsAborted() {
+			return
+		}
+		user := c.MustGet("user").(*rancherUser)
+		if !activeRBACPolicy.allows(user, perm) {
+			permissionDenied(c, user, perm)
+		}
+	}
+}
+
+func permissionDenied(c *gin.Context, user *rancherUser, perm string) {
+	c.AbortWithStatusJSON(403, gin.H{
+		"error":      fmt.Sprintf("permission %q denied for user %s", perm, user.Username),
+		"permission": perm,
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestPermissionsFor(t *testing.T) {
+	all := append([]string(nil), allPermissions...)
+	sort.Strings(all)
+	policy := rbacPolicy{
+		Default: []string{permCatalogView},
+		Rules: []rbacRule{
+			{GlobalRoles: []string{"admin"}, Permissions: []string{"*"}},
+			{Groups: []string{"github_team://1"}, Permissions: []string{permPluginsManage, permShellOpen}},
+			{Users: []string{"u-abc"}, Permissions: []string{permFSBrowse}},
+		},
+	}
+	tests := []struct {
+		name string
+		user rancherUser
+		want []string
+	}{
+		{"default only", rancherUser{ID: "u-x"}, []string{permCatalogView}},
+		{"admin gets everything", rancherUser{ID: "u-x", GlobalRoles: []string{"user", "admin"}}, all},
+		{"group", rancherUser{ID: "u-x", Groups: []string{"github_team://1"}}, []string{permCatalogView, permPluginsManage, permShellOpen}},
+		{"user", rancherUser{ID: "u-abc"}, []string{permCatalogView, permFSBrowse}},
+		{"group and user add up", rancherUser{ID: "u-abc", Groups: []string{"github_team://1"}}, []string{permCatalogView, permFSBrowse, permPluginsManage, permShellOpen}},
+		{"role name is not a group", rancherUser{ID: "u-x", Groups: []string{"admin"}}, []string{permCatalogView}},
+		{"user ID is not a role", rancherUser{ID: "admin"}, []string{permCatalogView}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := policy.permissionsFor(&tt.user); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("permissionsFor = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPermissionsForEmptyPolicy(t *testing.T) {
+	if got := (rbacPolicy{}).permissionsFor(&rancherUser{ID: "u-x", GlobalRoles: []string{"admin"}}); len(got) != 0 {
+		t.Errorf("permissionsFor = %v, want nothing", got)
+	}
+}
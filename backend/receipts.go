@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pluginReceipt is what krew writes to $KREW_ROOT/receipts/<name>.yaml on
+// install: the manifest that was installed plus where it came from.
+type pluginReceipt struct {
+	pluginManifest `yaml:",inline"`
+	Status         struct {
+		Source struct {
+			Name string `yaml:"name"`
+		} `yaml:"source"`
+	} `yaml:"status"`
+}
+
+// installedPlugin describes one installed plugin from its receipt.
+type installedPlugin struct {
+	Name        string         `json:"name"`
+	Version     string         `json:"version"`
+	Index       string         `json:"index"`
+	InstalledAt *time.Time     `json:"installedAt,omitempty"`
+	BinPath     string         `json:"binPath"`
+	Target      string         `json:"target,omitempty"`
+	Selector    *labelSelector `json:"selector,omitempty"`
+
+	// Filled in from the index by installedWithUpdates
+	LatestVersion string `json:"latestVersion,omitempty"`
+	Upgradable    bool   `json:"upgradable"`
+
+	// Set by installedWithUpdates when the plugin policy forbids the
+	// installed version, e.g. one installed with krew in the shell
+	PolicyViolation string `json:"policyViolation,omitempty"`
+}
+
+// pluginBinName is the name of the PATH shim krew creates for a plugin.
+func pluginBinName(name string) string {
+	return "kubectl-" + strings.ReplaceAll(name, "-", "_")
+}
+
+// matches reports whether the selector matches labels, following the
+// Kubernetes label selector semantics krew uses for platforms.
+func (s *labelSelector) matches(labels map[string]string) bool {
+	if s == nil {
+		return true
+	}
+	for k, v := range s.MatchLabels {
+		if labels[k] != v {
+			return false
+		}
+	}
+	for _, req := range s.MatchExpressions {
+		v, ok := labels[req.Key]
+		switch req.Operator {
+		case "In":
+			if !ok || !containsString(req.Values, v) {
+				return false
+			}
+		case "NotIn":
+			if ok && containsString(req.Values, v) {
+				return false
+			}
+		case "Exists":
+			if !ok {
+				return false
+			}
+		case "DoesNotExist":
+			if ok {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// hostPlatform picks the platform of spec that krew selects on this host:
+// the first one whose selector matches os and arch.
+func hostPlatform(spec pluginSpec) *pluginPlatform {
+	labels := map[string]string{"os": runtime.GOOS, "arch": runtime.GOARCH}
+	for i := range spec.Platforms {
+		if spec.Platforms[i].Selector.matches(labels) {
+			return &spec.Platforms[i]
+		}
+	}
+	return nil
+}
+
+func readReceipt(root, file string) (*installedPlugin, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var r pluginReceipt
+	if err := yaml.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parse receipt %s: %w", file, err)
+	}
+	name := r.Metadata.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(file), ".yaml")
+	}
+	index := r.Status.Source.Name
+	if index == "" {
+		index = defaultIndex
+	}
+	p := &installedPlugin{
+		Name:    name,
+		Version: r.Spec.Version,
+		Index:   index,
+		BinPath: filepath.Join(root, "bin", pluginBinName(name)),
+	}
+	if index != defaultIndex {
+		p.Name = index + "/" + name
+	}
+	if t, err := time.Parse(time.RFC3339, r.Metadata.CreationTimestamp); err == nil {
+		p.InstalledAt = &t
+	}
+	if target, err := filepath.EvalSymlinks(p.BinPath); err == nil {
+		p.Target = target
+	}
+	if platform := hostPlatform(r.Spec); platform != nil {
+		p.Selector = platform.Selector
+	}
+	return p, nil
+}
+
+// installedPlugins reads all receipts of ws, keyed by qualified name.
+func installedPlugins(ws *workspace) (map[string]*installedPlugin, error) {
+	files, err := filepath.Glob(filepath.Join(ws.KrewRoot, "receipts", "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	installed := make(map[string]*installedPlugin, len(files))
+	for _, f := range files {
+		p, err := readReceipt(ws.KrewRoot, f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "receipts: %v\n", err)
+			continue
+		}
+		installed[p.Name] = p
+	}
+	return installed, nil
+}
+
+// sortedInstalled returns the installed plugins sorted by name.
+func sortedInstalled(installed map[string]*installedPlugin) []*installedPlugin {
+	list := make([]*installedPlugin, 0, len(installed))
+	for _, p := range installed {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Workstation users get a dedicated UID (and a group with the same GID) from
+// the pool SHELL_UID_MIN..SHELL_UID_MAX. The UID is recorded per workspace in
+// uidDir, which belongs to the backend user, so it stays stable across
+// restarts and workspace users cannot change it.
+var uidMu sync.Mutex
+
+func envInt(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return v
+	}
+	return def
+}
+
+func uidDir() string {
+	return filepath.Join(workspacesDir(), ".uids")
+}
+
+func uidPool() (lo, hi int) {
+	return envInt("SHELL_UID_MIN", 20000), envInt("SHELL_UID_MAX", 29999)
+}
+
+// shellUsersEnabled reports whether workspace processes drop to per-user
+// credentials. It needs root and can be turned off with SHELL_RUN_AS_ROOT.
+func shellUsersEnabled() bool {
+	return os.Getenv("SHELL_RUN_AS_ROOT") != "true" && os.Geteuid() == 0
+}
+
+// assignedUIDs reads the UID map, by workspace key.
+func assignedUIDs() map[string]int {
+	uids := make(map[string]int)
+	files, _ := os.ReadDir(uidDir())
+	for _, f := range files {
+		if data, err := os.ReadFile(filepath.Join(uidDir(), f.Name())); err == nil {
+			if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+				uids[f.Name()] = n
+			}
+		}
+	}
+	return uids
+}
+
+// allocateUID returns the UID of w, picking the lowest free one from the
+// pool on first use. fresh is true when the UID was just allocated. A
+// workspace from before the UID map keeps the UID owning its directory if
+// that is a free pool UID.
+func allocateUID(w *workspace) (uid int, fresh bool, err error) {
+	uidMu.Lock()
+	defer uidMu.Unlock()
+
+	key := workspaceKey(w.UserID)
+	lo, hi := uidPool()
+	uids := assignedUIDs()
+	if uid, ok := uids[key]; ok {
+		if uid < lo || uid > hi {
+			return 0, false, fmt.Errorf("UID %d of %s is outside the pool %d-%d", uid, w.UserID, lo, hi)
+		}
+		return uid, false, nil
+	}
+
+	used := make(map[int]bool)
+	for _, n := range uids {
+		used[n] = true
+	}
+	if err := os.MkdirAll(uidDir(), 0700); err != nil {
+		return 0, false, err
+	}
+	record := func(uid int) error {
+		return os.WriteFile(filepath.Join(uidDir(), key), []byte(strconv.Itoa(uid)+"\n"), 0600)
+	}
+	if fi, err := os.Stat(w.Dir); err == nil {
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+			if owner := int(st.Uid); owner >= lo && owner <= hi && !used[owner] {
+				return owner, false, record(owner)
+			}
+		}
+	}
+	for uid := lo; uid <= hi; uid++ {
+		if used[uid] {
+			continue
+		}
+		if err := record(uid); err != nil {
+			return 0, false, err
+		}
+		return uid, true, nil
+	}
+	return 0, false, fmt.Errorf("no free UID in pool %d-%d", lo, hi)
+}
+
+// ensurePasswdEntry adds w's user and group to /etc/passwd and /etc/group so
+// the shell prompt, ssh and friends can resolve the UID.
+func ensurePasswdEntry(w *workspace) error {
+	name := "ws-" + strconv.Itoa(w.UID)
+	entries := []struct{ path, line string }{
+		{"/etc/group", fmt.Sprintf("%s:x:%d:", name, w.GID)},
+		{"/etc/passwd", fmt.Sprintf("%s:x:%d:%d:%s:%s:/bin/sh", name, w.UID, w.GID, w.UserID, w.Home)},
+	}
+	uidMu.Lock()
+	defer uidMu.Unlock()
+	for _, e := range entries {
+		data, err := os.ReadFile(e.path)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(data), "\n"+name+":") || strings.HasPrefix(string(data), name+":") {
+			continue
+		}
+		f, err := os.OpenFile(e.path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(f, e.line)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chown hands path (recursively) to the workspace user. It is a no-op when
+// processes run as root.
+func (w *workspace) chown(path string) error {
+	if w.UID == 0 {
+		return nil
+	}
+	return filepath.Walk(path, func(p string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(p, w.UID, w.GID)
+	})
+}
+
+// writeFile writes data to path in the workspace as the workspace user,
+// creating its directory. The backend must not write there as root, since
+// the user may have replaced path or a directory with a symlink to any file.
+func (w *workspace) writeFile(path string, data []byte, perm os.FileMode) error {
+	if w.UID == 0 {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		return os.WriteFile(path, data, perm)
+	}
+	cmd := w.command("sh", "-c", `mkdir -p -m 700 "$(dirname "$2")" && umask "$1" && cat > "$2"`,
+		"sh", fmt.Sprintf("%03o", 0777&^perm.Perm()), path)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("write %s: %w: %s", path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// readFile reads path in the workspace as the workspace user, for the same
+// reason as writeFile.
+func (w *workspace) readFile(path string) ([]byte, error) {
+	if w.UID == 0 {
+		return os.ReadFile(path)
+	}
+	if _, err := os.Lstat(path); err != nil {
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	cmd := w.command("cat", "--", path)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// credential returns the process attributes that run a command as the
+// workspace user.
+func (w *workspace) credential() *syscall.SysProcAttr {
+	if w.UID == 0 {
+		return nil
+	}
+	return &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(w.UID), Gid: uint32(w.GID)},
+	}
+}
+
+// shellLimits returns the ulimit commands applied before an interactive
+// shell starts: processes, open files and virtual memory (MiB).
+func shellLimits() string {
+	var b strings.Builder
+	if n := envInt("SHELL_LIMIT_NPROC", 256); n > 0 {
+		fmt.Fprintf(&b, "ulimit -u %d; ", n)
+	}
+	if n := envInt("SHELL_LIMIT_NOFILE", 1024); n > 0 {
+		fmt.Fprintf(&b, "ulimit -n %d; ", n)
+	}
+	if n := envInt("SHELL_LIMIT_MEMORY_MB", 2048); n > 0 {
+		fmt.Fprintf(&b, "ulimit -v %d; ", n*1024)
+	}
+	return b.String()
+}
+
+// killSession kills every process in the session sid. Background jobs of an
+// interactive shell get process groups of their own but stay in its
+// session; only a process that calls setsid itself escapes. A few passes
+// catch children forked while the previous pass ran.
+func killSession(sid int) {
+	for pass := 0; pass < 5; pass++ {
+		stats, _ := filepath.Glob("/proc/[0-9]*/stat")
+		found := false
+		for _, f := range stats {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				continue
+			}
+			// The command name in parentheses may contain spaces; the
+			// fields after it are state, ppid, pgrp and session.
+			i := bytes.LastIndexByte(data, ')')
+			if i < 0 {
+				continue
+			}
+			fields := strings.Fields(string(data[i+1:]))
+			if len(fields) < 4 || fields[3] != strconv.Itoa(sid) {
+				continue
+			}
+			pid, err := strconv.Atoi(filepath.Base(filepath.Dir(f)))
+			if err != nil {
+				continue
+			}
+			if err := syscall.Kill(pid, syscall.SIGKILL); err != nil {
+				if err != syscall.ESRCH {
+					fmt.Fprintf(os.Stderr, "kill session %d: pid %d: %v\n", sid, pid, err)
+				}
+				continue
+			}
+			found = true
+		}
+		if !found {
+			return
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Stream markers of transcript lines.
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+	streamSystem = "system"
+)
+
+// jobLine is one line of a job transcript.
+type jobLine struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Text   string    `json:"text"`
+}
+
+// lineFunc receives output line by line as a command produces it. Calls
+// never overlap, so it needs no locking of its own.
+type lineFunc func(stream, text string)
+
+// serialLines returns emit guarded by a mutex, for output read by several
+// goroutines.
+func serialLines(emit lineFunc) lineFunc {
+	var mu sync.Mutex
+	return func(stream, text string) {
+		mu.Lock()
+		defer mu.Unlock()
+		emit(stream, text)
+	}
+}
+
+func discardLines(string, string) {}
+
+// collectLines returns a lineFunc that appends every line to b. It does not
+// lock b; runKrewStream never calls it from two goroutines at once.
+func collectLines(b *strings.Builder) lineFunc {
+	return func(_, text string) {
+		b.WriteString(text)
+		b.WriteByte('\n')
+	}
+}
+
+// scanTerminalLines is bufio.ScanLines that also splits on a bare "\r", so
+// progress bars that redraw one line show up as they advance.
+func scanTerminalLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+		if data[i] == '\r' && i+1 == len(data) && !atEOF {
+			return 0, nil, nil // might be the start of "\r\n"
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+func pipeLines(r io.Reader, stream string, emit lineFunc) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	sc.Split(scanTerminalLines)
+	for sc.Scan() {
+		emit(stream, sc.Text())
+	}
+}
+
+// runKrewStream runs a krew command in ws like runKrewContext, but hands
+// stdout and stderr to emit line by line while krew runs.
+func runKrewStream(ctx context.Context, ws *workspace, emit lineFunc, args ...string) error {
+	what := "krew " + strings.Join(args, " ")
+	emit = serialLines(emit)
+	release, err := lockKrew(ctx, ws, args)
+	if err != nil {
+		return fmt.Errorf("%s: %w", what, err)
+	}
+	defer release()
+
+	timeout := operationTimeout(krewOperation(args))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := ws.commandContext(ctx, "kubectl", append([]string{"krew"}, args...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	emit(streamSystem, "$ kubectl krew "+strings.Join(args, " "))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s failed: %w", what, err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); pipeLines(stdout, streamStdout, emit) }()
+	go func() { defer wg.Done(); pipeLines(stderr, streamStderr, emit) }()
+	wg.Wait()
+	err = cmd.Wait()
+	switch krewOperation(args) {
+	case "install", "upgrade", "uninstall":
+		// Even a failed upgrade may have replaced some plugins.
+		if rerr := recordPluginHashes(ws, false); rerr != nil {
+			fmt.Fprintf(os.Stderr, "audit: %v\n", rerr)
+		}
+	}
+	if err != nil {
+		err = subprocessError(ctx, what, timeout, err)
+		emit(streamSystem, err.Error())
+		return err
+	}
+	if krewOperation(args) == "update" {
+		markIndexUpdated(ws)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Default subprocess timeouts per operation. Each can be overridden with
+// SUBPROCESS_TIMEOUT_<OPERATION> (a Go duration, e.g. "15m").
+var defaultTimeouts = map[string]time.Duration{
+	"install":   10 * time.Minute,
+	"upgrade":   10 * time.Minute,
+	"uninstall": 2 * time.Minute,
+	"update":    5 * time.Minute,
+	"index":     5 * time.Minute,
+	"krew":      time.Minute, // read-only krew commands: list, search, info, ...
+	"kubectl":   30 * time.Second,
+}
+
+// operationTimeout returns the timeout for op.
+func operationTimeout(op string) time.Duration {
+	if v := os.Getenv("SUBPROCESS_TIMEOUT_" + strings.ToUpper(op)); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+		fmt.Fprintf(os.Stderr, "ignoring invalid SUBPROCESS_TIMEOUT_%s=%q\n", strings.ToUpper(op), v)
+	}
+	return defaultTimeouts[op]
+}
+
+// krewOperation maps krew arguments to the operation whose timeout applies.
+func krewOperation(args []string) string {
+	if len(args) > 0 {
+		switch args[0] {
+		case "install", "upgrade", "uninstall", "update", "index":
+			return args[0]
+		}
+	}
+	return "krew"
+}
+
+// subprocessError wraps the error of a finished subprocess, naming what ran
+// and saying so clearly when it was killed by its timeout or a cancel.
+func subprocessError(ctx context.Context, what string, timeout time.Duration, err error) error {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return fmt.Errorf("%s timed out after %s: %w", what, timeout, context.DeadlineExceeded)
+	case context.Canceled:
+		return fmt.Errorf("%s canceled: %w", what, context.Canceled)
+	}
+	return fmt.Errorf("%s failed: %w", what, err)
+}
+
+// errorStatus is the HTTP status for a failed subprocess: 504 for a
+// timeout, 500 otherwise.
+func errorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return 504
+	}
+	return 500
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// workspace is the per-user state of the workstation: a home directory with
+// its own kubeconfig and shell history, and a private KREW_ROOT. The shared
+// krewRoot() only provides the krew binary itself.
+type workspace struct {
+	UserID   string `json:"userId"`
+	Dir      string `json:"dir"`
+	Home     string `json:"home"`
+	KrewRoot string `json:"krewRoot"`
+
+	// UID and GID the workspace processes run as; 0 when shell users are
+	// disabled and everything runs as the backend user.
+	UID int `json:"uid"`
+	GID int `json:"gid"`
+}
+
+var (
+	workspaceMu    sync.Mutex
+	workspaceLocks = make(map[string]*sync.Mutex)
+)
+
+func workspacesDir() string {
+	if d := os.Getenv("WORKSPACES_DIR"); d != "" {
+		return d
+	}
+	return "/workspaces"
+}
+
+// workspaceKey turns a Rancher user ID into a safe directory name.
+func workspaceKey(userID string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, userID)
+}
+
+func (w *workspace) kubeConfigPath() string {
+	return filepath.Join(w.Home, ".kube", "config")
+}
+
+// inheritedEnv lists the backend variables workspace processes get. The
+// backend environment holds secrets such as RANCHER_TOKEN, so nothing else
+// is passed on.
+var inheritedEnv = []string{
+	"TERM", "LANG", "LANGUAGE", "LC_ALL", "LC_CTYPE", "TZ",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+	"SSL_CERT_FILE", "SSL_CERT_DIR",
+}
+
+// env returns the environment for processes running in the workspace.
+func (w *workspace) env() []string {
+	var env []string
+	for _, key := range inheritedEnv {
+		if v, ok := os.LookupEnv(key); ok {
+			env = append(env, key+"="+v)
+		}
+	}
+	return append(env,
+		"HOME="+w.Home,
+		"KUBECONFIG="+w.kubeConfigPath(),
+		"KREW_ROOT="+w.KrewRoot,
+		"HISTFILE="+filepath.Join(w.Home, ".bash_history"),
+		fmt.Sprintf("PATH=%s:%s:%s", filepath.Join(w.KrewRoot, "bin"), filepath.Join(krewRoot(), "bin"), os.Getenv("PATH")),
+		// The local index repository belongs to the backend user; let
+		// workspace users clone and fetch it.
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=safe.directory",
+		"GIT_CONFIG_VALUE_0="+localIndexRepo(),
+	)
+}
+
+// command prepares name to run as the workspace user, with the workspace
+// environment and the home directory as working directory.
+func (w *workspace) command(name string, args ...string) *exec.Cmd {
+	return w.commandContext(context.Background(), name, args...)
+}
+
+// commandContext is command with a context. The process runs in its own
+// process group, and the whole group is killed when ctx is done, so plugin
+// downloads or helpers started by krew and kubectl do not outlive it.
+func (w *workspace) commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = w.env()
+	cmd.Dir = w.Home
+	cmd.SysProcAttr = w.credential()
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.Cancel = func() error {
+		// Without CAP_KILL this fails with EPERM for workspace UIDs and the
+		// process keeps running, so say so.
+		err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		if err != nil && err != syscall.ESRCH {
+			fmt.Fprintf(os.Stderr, "kill %s (pid %d): %v\n", name, cmd.Process.Pid, err)
+		}
+		return err
+	}
+	// Do not wait forever for output pipes held open by orphaned children.
+	cmd.WaitDelay = 5 * time.Second
+	return cmd
+}
+
+// workspaceFor returns the workspace of user, creating it on first use.
+func workspaceFor(user *rancherUser) (*workspace, error) {
+	key := workspaceKey(user.ID)
+	if key == "" || key == "." || key == ".." {
+		return nil, fmt.Errorf("invalid user ID %q", user.ID)
+	}
+	dir := filepath.Join(workspacesDir(), key)
+	w := &workspace{
+		UserID:   user.ID,
+		Dir:      dir,
+		Home:     filepath.Join(dir, "home"),
+		KrewRoot: filepath.Join(dir, "krew"),
+	}
+
+	workspaceMu.Lock()
+	lock, ok := workspaceLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		workspaceLocks[key] = lock
+	}
+	workspaceMu.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+	if err := w.init(); err != nil {
+		return nil, fmt.Errorf("workspace for %s: %w", user.ID, err)
+	}
+	activeWorkspaces.Store(w.KrewRoot, w)
+	return w, nil
+}
+
+// init creates the workspace directories, assigns the workspace user, seeds
+// the shell profile and fetches the krew index on first use.
+func (w *workspace) init() error {
+	if err := os.MkdirAll(workspacesDir(), 0711); err != nil {
+		return err
+	}
+	for _, d := range []string{filepath.Dir(w.kubeConfigPath()), w.KrewRoot} {
+		if err := os.MkdirAll(d, 0700); err != nil {
+			return err
+		}
+	}
+	if shellUsersEnabled() {
+		uid, fresh, err := allocateUID(w)
+		if err != nil {
+			return err
+		}
+		// Never let a workspace run as root, whatever the pool says.
+		if lo, hi := uidPool(); uid <= 0 || uid < lo || uid > hi {
+			return fmt.Errorf("refusing UID %d for %s (pool %d-%d)", uid, w.UserID, lo, hi)
+		}
+		w.UID, w.GID = uid, uid
+		if err := ensurePasswdEntry(w); err != nil {
+			return fmt.Errorf("register user %d: %w", uid, err)
+		}
+		if fresh {
+			if err := w.chown(w.Dir); err != nil {
+				return err
+			}
+		}
+	}
+	bashrc := filepath.Join(w.Home, ".bashrc")
+	if _, err := os.Lstat(bashrc); os.IsNotExist(err) {
+		if data, err := os.ReadFile("/root/.bashrc"); err == nil {
+			if err := w.writeFile(bashrc, data, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	// Without an index the workspace still takes uploads and offline
+	// installs, so a failure here must not lock the user out.
+	if _, err := os.Stat(filepath.Join(w.KrewRoot, "index")); os.IsNotExist(err) {
+		if err := w.seedIndex(); err != nil {
+			fmt.Fprintf(os.Stderr, "workspace %s: %v\n", w.UserID, err)
+		}
+	}
+	// A broken local index must not lock users out of their workspace.
+	if err := registerLocalIndex(w); err != nil {
+		fmt.Fprintf(os.Stderr, "workspace %s: %v\n", w.UserID, err)
+	}
+	return nil
+}
+
+// seedIndex gives a new workspace the default krew index: a copy of the one
+// in the shared krew root, which the image ships, so air-gapped clusters get
+// an index too, or else a fresh krew update. It runs as the workspace user.
+func (w *workspace) seedIndex() error {
+	shared := filepath.Join(krewRoot(), "index", defaultIndex)
+	if _, err := os.Stat(shared); err == nil {
+		dir := filepath.Join(w.KrewRoot, "index")
+		out, err := w.command("sh", "-c", `mkdir -p "$1" && cp -R "$2" "$1"/`, "sh", dir, shared).CombinedOutput()
+		if err == nil {
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "workspace %s: copy the shared krew index: %v\n%s", w.UserID, err, out)
+		w.command("rm", "-rf", dir).Run()
+	}
+	if out, err := runKrew(w, "update"); err != nil {
+		return fmt.Errorf("initialize krew index: %w\n%s", err, out)
+	}
+	return nil
+}
+
+// currentWorkspace returns the workspace of the user authenticated by
+// requireSession or requirePermission, answering 500 on failure.
+func currentWorkspace(c *gin.Context) (*workspace, bool) {
+	user := c.MustGet("user").(*rancherUser)
+	ws, err := workspaceFor(user)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return ws, true
+}